@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// adminSession - the session of a logged in admin, nil after answering
+// the request for anyone else
+func adminSession(res http.ResponseWriter, req *http.Request) *userInfo {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return nil
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return nil
+	}
+	if !admins[usr.Username] {
+		http.NotFound(res, req)
+		return nil
+	}
+	return usr
+}
+
+// adminWithdrawalsPage - lists the withdrawals waiting for approval
+func adminWithdrawalsPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	usr := adminSession(res, req)
+	if usr == nil {
+		return
+	}
+	response := walletCmd("admin", "withdrawals")
+	if response.Status != "OK" {
+		http.Error(res, "Error loading withdrawals", http.StatusInternalServerError)
+		return
+	}
+
+	pg := pageInfo{URI: hostURI, Messages: map[string]interface{}{}}
+	if msg, err := req.Cookie("adminMessage"); err == nil {
+		if strings.HasPrefix(msg.Value, "Error!: ") {
+			pg.Messages["error"] = msg.Value
+		} else {
+			pg.Messages["success"] = msg.Value
+		}
+		http.SetCookie(res, &http.Cookie{Name: "adminMessage", Path: "/admin", MaxAge: -1})
+	}
+
+	data := struct {
+		User        userInfo
+		PageAttr    pageInfo
+		Withdrawals map[string]interface{}
+	}{User: *usr, PageAttr: pg, Withdrawals: response.Data}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "admin.html", data))
+}
+
+// adminApproveHandler - sends a held withdrawal
+func adminApproveHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	usr := adminSession(res, req)
+	if usr == nil {
+		return
+	}
+	response := walletPost("withdrawals/approve", url.Values{
+		"id":            {req.FormValue("id")},
+		"admin":         {usr.Username},
+		"admin_address": {usr.Address},
+	})
+	message := "Error!: " + response.Status
+	if response.Status == "OK" {
+		hash, _ := response.Data["transactionHash"].(string)
+		message = "Withdrawal sent: " + hash
+	}
+	log.Println("withdrawal", req.FormValue("id"), "approved by", usr.Username+":", response.Status)
+	http.SetCookie(res, &http.Cookie{Name: "adminMessage", Path: "/admin", Value: message})
+	http.Redirect(res, req, hostURI+"/admin/withdrawals", http.StatusSeeOther)
+}
+
+// adminRejectHandler - refuses a held withdrawal with a reason
+func adminRejectHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	usr := adminSession(res, req)
+	if usr == nil {
+		return
+	}
+	response := walletPost("withdrawals/reject", url.Values{
+		"id":            {req.FormValue("id")},
+		"reason":        {req.FormValue("reason")},
+		"admin":         {usr.Username},
+		"admin_address": {usr.Address},
+	})
+	message := "Error!: " + response.Status
+	if response.Status == "OK" {
+		message = "Withdrawal rejected"
+	}
+	log.Println("withdrawal", req.FormValue("id"), "rejected by", usr.Username+":", response.Status)
+	http.SetCookie(res, &http.Cookie{Name: "adminMessage", Path: "/admin", Value: message})
+	http.Redirect(res, req, hostURI+"/admin/withdrawals", http.StatusSeeOther)
+}
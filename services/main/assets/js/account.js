@@ -24,12 +24,39 @@ function setWalletStatus () {
     console.log("checking wallet...");
   }
 
-function confirmation () {
-    let dest = document.getElementById("send_to").value;
-    let amount = document.getElementById("send_amount").value;
-    let conf_msg = document.getElementById("send_confirmation");
-    let sendTo = document.getElementById("send_to").value;
-    conf_msg.textContent = `You are sending ${amount} ${tickerSymbol} to: ${sendTo}`;
+function pickContact (picker) {
+    let option = picker.options[picker.selectedIndex];
+    if (option.value === "") {
+        return;
+    }
+    document.getElementById("send_to").value = option.value;
+    document.getElementById("s_paymentid").value = option.dataset.paymentid;
+    checkDestination();
+}
+
+// checkDestination - decodes the destination in the wallet service, which
+// verifies its checksum. Integrated addresses bring their own payment id.
+function checkDestination () {
+    let dest = document.getElementById("send_to");
+    let paymentID = document.getElementById("s_paymentid");
+    let check = document.getElementById("send_to_check");
+    paymentID.disabled = false;
+    dest.setCustomValidity("");
+    check.textContent = "";
+    if (dest.value.trim() === "") {
+        return;
+    }
+    let result = httpGet("/account/validate_address?address=" + encodeURIComponent(dest.value.trim()));
+    if (result.Status !== "OK") {
+        dest.setCustomValidity(result.Status);
+        check.textContent = result.Status;
+        return;
+    }
+    if (result.Data.integrated) {
+        paymentID.value = "";
+        paymentID.disabled = true;
+        check.textContent = "Integrated address, payment ID " + result.Data.paymentID;
+    }
 }
 
 function getUrlVars() {
@@ -51,6 +78,9 @@ function setPaymentForm() {
   if (vals.paymentid !== undefined) {
       document.getElementById('s_paymentid').value = vals.paymentid;
   }
+  if (vals.address !== undefined) {
+      checkDestination();
+  }
 }
 
 window.setInterval(setWalletStatus, updateInterval);
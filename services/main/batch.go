@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxBatchUpload - post size limit for batch uploads and confirmations
+const maxBatchUpload = 1 << 17
+
+// batchRow - a destination read from an uploaded csv
+type batchRow struct {
+	Destination string `json:"destination"`
+	Amount      string `json:"amount"`
+	PaymentID   string `json:"payment_id"`
+}
+
+// batchPage - shows the csv upload form
+func batchPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	renderBatch(res, req, usr, nil, "", nil)
+}
+
+// batchPreview - parses an uploaded csv and shows the planned transactions
+func batchPreview(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	file, _, err := req.FormFile("csv")
+	if err != nil {
+		renderBatch(res, req, usr, nil, "", map[string]interface{}{"error": "Choose a csv file to upload"})
+		return
+	}
+	defer file.Close()
+	rows, err := parseBatchCSV(file)
+	if err != nil {
+		renderBatch(res, req, usr, nil, "", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	transfers, _ := json.Marshal(rows)
+	response := walletPost("send_batch", url.Values{
+		"address":   {usr.Address},
+		"transfers": {string(transfers)},
+		"dry_run":   {"1"},
+	})
+	messages := map[string]interface{}{}
+	if response.Status != "OK" {
+		messages["error"] = response.Status
+	} else {
+		messages["preview"] = true
+	}
+	renderBatch(res, req, usr, response.Data, string(transfers), messages)
+}
+
+// batchSend - sends a previewed batch
+func batchSend(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("send_batch", url.Values{
+		"address":     {usr.Address},
+		"transfers":   {req.FormValue("transfers")},
+		"request_key": {req.FormValue("request_key")},
+	})
+	messages := map[string]interface{}{}
+	if response.Status != "OK" {
+		messages["error"] = response.Status
+	} else {
+		messages["sent"] = true
+		messages["duplicate"] = response.Data["duplicate"]
+	}
+	renderBatch(res, req, usr, response.Data, "", messages)
+}
+
+// renderBatch - renders the batch page
+func renderBatch(res http.ResponseWriter, req *http.Request, usr *userInfo,
+	batch map[string]interface{}, transfers string, messages map[string]interface{}) {
+	if messages == nil {
+		messages = map[string]interface{}{}
+	}
+	data := struct {
+		User       userInfo
+		PageAttr   pageInfo
+		Batch      map[string]interface{}
+		Transfers  string
+		RequestKey string // identifies the previewed batch, so a resubmitted confirmation sends it once
+	}{
+		User:       *usr,
+		PageAttr:   pageInfo{URI: hostURI, Messages: messages},
+		Batch:      batch,
+		Transfers:  transfers,
+		RequestKey: newRequestKey(),
+	}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "batch.html", data))
+}
+
+// parseBatchCSV - reads destination,amount[,payment_id] rows, a header row
+// starting with "address" or "destination" is skipped
+func parseBatchCSV(r io.Reader) ([]batchRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows := []batchRow{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && len(record) > 0 {
+			header := strings.ToLower(strings.TrimSpace(record[0]))
+			if header == "address" || header == "destination" {
+				continue
+			}
+		}
+		if len(record) < 2 || len(record) > 3 {
+			return nil, errors.New("Each row needs an address, an amount and optionally a payment ID")
+		}
+		row := batchRow{
+			Destination: strings.TrimSpace(record[0]),
+			Amount:      strings.TrimSpace(record[1]),
+		}
+		if len(record) == 3 {
+			row.PaymentID = strings.TrimSpace(record[2])
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("The csv file has no rows")
+	}
+	return rows, nil
+}
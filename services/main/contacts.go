@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// contactsPage - shows the address book of the user
+func contactsPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletCmd("contacts", usr.Address)
+	if response.Status != "OK" {
+		http.Error(res, "Error loading address book", http.StatusInternalServerError)
+		return
+	}
+
+	pg := pageInfo{URI: hostURI, Messages: map[string]interface{}{}}
+	if msg, err := req.Cookie("contactMessage"); err == nil {
+		if strings.HasPrefix(msg.Value, "Error!: ") {
+			pg.Messages["error"] = msg.Value
+		} else {
+			pg.Messages["success"] = msg.Value
+		}
+		http.SetCookie(res, &http.Cookie{Name: "contactMessage", Path: "/account", MaxAge: -1})
+	}
+
+	data := struct {
+		User     userInfo
+		PageAttr pageInfo
+		Contacts interface{}
+	}{User: *usr, PageAttr: pg, Contacts: response.Data["contacts"]}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "contacts.html", data))
+}
+
+// contactHandler - adds a contact, or updates it when an id is posted
+func contactHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("contacts", url.Values{
+		"address":         {usr.Address},
+		"id":              {req.FormValue("id")},
+		"name":            {req.FormValue("name")},
+		"contact_address": {req.FormValue("contact_address")},
+		"payment_id":      {req.FormValue("payment_id")},
+		"notes":           {req.FormValue("notes")},
+	})
+	message := "Contact saved"
+	if response.Status != "OK" {
+		message = "Error!: " + response.Status
+	}
+	http.SetCookie(res, &http.Cookie{Name: "contactMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account/contacts", http.StatusSeeOther)
+}
+
+// contactDeleteHandler - removes a contact
+func contactDeleteHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("contacts/delete", url.Values{
+		"address": {usr.Address},
+		"id":      {req.FormValue("id")},
+	})
+	message := "Contact removed"
+	if response.Status != "OK" {
+		message = "Error!: " + response.Status
+	}
+	http.SetCookie(res, &http.Cookie{Name: "contactMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account/contacts", http.StatusSeeOther)
+}
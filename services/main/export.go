@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var exportFormats = map[string]bool{"csv": true, "json": true, "ofx": true}
+
+// exportHandler - streams the full transaction history of the user as a
+// csv, json or ofx download
+func exportHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	format := req.FormValue("format")
+	if !exportFormats[format] {
+		http.Error(res, "Unknown export format", http.StatusBadRequest)
+		return
+	}
+	query := url.Values{
+		"format": {format},
+		"from":   {req.FormValue("from")},
+		"to":     {req.FormValue("to")},
+	}
+	resb, err := http.Get(walletURI + "/export/" + usr.Address + "?" + query.Encode())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer resb.Body.Close()
+	if resb.StatusCode != http.StatusOK {
+		response := jsonResponse{}
+		json.NewDecoder(resb.Body).Decode(&response)
+		http.Error(res, response.Status, http.StatusBadRequest)
+		return
+	}
+	res.Header().Set("Content-Type", resb.Header.Get("Content-Type"))
+	res.Header().Set("Content-Disposition", `attachment; filename="shellnet-history.`+format+`"`)
+	if _, err = io.Copy(res, resb.Body); err != nil {
+		log.Println("Error: export:", err)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,8 +26,39 @@ func InitHandlers(r *httprouter.Router) {
 	r.GET("/account/keys", limit(walletKeys, ratelimiter))
 	r.POST("/account/delete", limit(deleteHandler, ratelimiter))
 	r.GET("/account/wallet_info", limit(getWalletInfo, ratelimiter))
+	r.GET("/account/validate_address", limit(validateAddressHandler, ratelimiter))
 	r.POST("/account/export_keys", limit(keyHandler, ratelimiter))
+	r.POST("/account/seed_backup", limit(seedBackupHandler, ratelimiter))
 	r.POST("/account/send_transaction", limit(sendHandler, ratelimiter))
+	r.POST("/account/send_transaction/confirm", limit(confirmHandler, ratelimiter))
+	r.POST("/account/send_transaction/cancel", limit(cancelHandler, ratelimiter))
+	r.POST("/account/optimize", limit(optimizeHandler, ratelimiter))
+	r.GET("/account/integrated_addresses", limit(integratedPage, ratelimiter))
+	r.POST("/account/integrated_address", limit(integratedHandler, ratelimiter))
+	r.GET("/account/batch", limit(batchPage, ratelimiter))
+	r.POST("/account/batch/preview", limitBody(batchPreview, ratelimiter, maxBatchUpload))
+	r.POST("/account/batch/send", limitBody(batchSend, ratelimiter, maxBatchUpload))
+	r.GET("/account/webhooks", limit(webhooksPage, ratelimiter))
+	r.POST("/account/webhooks", limit(webhookHandler, ratelimiter))
+	r.POST("/account/webhooks/delete", limit(webhookDeleteHandler, ratelimiter))
+	r.GET("/account/invoices", limit(invoicesPage, ratelimiter))
+	r.POST("/account/invoices", limit(invoiceHandler, ratelimiter))
+	r.GET("/invoice/:id", limit(invoicePage, ratelimiter))
+	r.GET("/account/contacts", limit(contactsPage, ratelimiter))
+	r.POST("/account/contacts", limit(contactHandler, ratelimiter))
+	r.POST("/account/contacts/delete", limit(contactDeleteHandler, ratelimiter))
+	r.GET("/account/watch", limit(watchPage, ratelimiter))
+	r.POST("/account/watch", limit(watchHandler, ratelimiter))
+	r.POST("/account/watch/delete", limit(watchDeleteHandler, ratelimiter))
+	r.GET("/account/policy", limit(policyPage, ratelimiter))
+	r.POST("/account/policy", limit(policyHandler, ratelimiter))
+	r.POST("/account/policy/cancel", limit(policyCancelHandler, ratelimiter))
+	r.POST("/account/policy/allow", limit(allowHandler, ratelimiter))
+	r.POST("/account/policy/allow/delete", limit(allowDeleteHandler, ratelimiter))
+	r.GET("/admin/withdrawals", limit(adminWithdrawalsPage, ratelimiter))
+	r.POST("/admin/withdrawals/approve", limit(adminApproveHandler, ratelimiter))
+	r.POST("/admin/withdrawals/reject", limit(adminRejectHandler, ratelimiter))
+	r.GET("/account/export", limit(exportHandler, ratelimiter))
 	r.Handler(http.MethodGet, "/captcha/*name",
 		captcha.Server(captcha.StdWidth, captcha.StdHeight))
 	r.Handler(http.MethodGet, "/assets/*filepath", http.StripPrefix("/assets",
@@ -62,29 +95,68 @@ func accountPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params
 		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
 		return
 	}
+	health := walletHealth(usr.Address)
 	walletResponse := walletCmd("status", usr.Address)
 	if walletResponse.Status != "OK" {
-		http.Error(res, "Error loading wallet status", http.StatusInternalServerError)
-		return
+		if health == healthHealthy {
+			http.Error(res, "Error loading wallet status", http.StatusInternalServerError)
+			return
+		}
+		// the page still loads, with the reason sending is disabled
+		walletResponse.Data = map[string]interface{}{
+			"status":  map[string]interface{}{"blockCount": 0.0, "knownBlockCount": 0.0},
+			"balance": map[string]interface{}{"availableBalance": 0.0, "lockedAmount": 0.0},
+		}
 	}
 	walletIcon := walletStatusColor(walletResponse)
 
 	pg := pageInfo{
 		URI:      hostURI,
-		Messages: map[string]interface{}{"wallet_icon": walletIcon},
+		Messages: map[string]interface{}{"wallet_icon": walletIcon, "health": healthMessages[health]},
 	}
 	if txHash, err := req.Cookie("transactionHash"); err == nil {
 		pg.Messages["txHash"] = txHash.Value
 		http.SetCookie(res, &http.Cookie{Name: "transactionHash", Path: "/account", MaxAge: -1})
 	}
+	if msg, err := req.Cookie("optimizeMessage"); err == nil {
+		pg.Messages["optimize"] = msg.Value
+		http.SetCookie(res, &http.Cookie{Name: "optimizeMessage", Path: "/account", MaxAge: -1})
+	}
+	if msg, err := req.Cookie("seedMessage"); err == nil {
+		pg.Messages["seed"] = msg.Value
+		http.SetCookie(res, &http.Cookie{Name: "seedMessage", Path: "/account", MaxAge: -1})
+	}
 
-	txs := walletCmd("transactions/"+usr.Address, "0")
+	filter := historyQuery(req)
+	query := url.Values{"cursor": {req.FormValue("cursor")}}
+	for name := range filter {
+		query.Set(name, filter.Get(name))
+	}
+	txs := walletCmd("transactions", usr.Address+"?"+query.Encode())
+	// pending transactions only belong at the top of the unfiltered history
+	if len(filter) == 0 && req.FormValue("cursor") == "" && txs.Status == "OK" {
+		if pending := walletCmd("pending", usr.Address); pending.Status == "OK" {
+			txs.Data["transactions"] = mergePending(pending.Data["transactions"], txs.Data["transactions"])
+		}
+	}
+	contacts := walletCmd("contacts", usr.Address)
+	watch := walletCmd("watch", usr.Address)
+	withdrawals := walletCmd("withdrawals", usr.Address)
 	data := struct {
 		User         userInfo
 		Wallet       map[string]interface{}
 		PageAttr     pageInfo
 		Transactions map[string]interface{}
-	}{User: *usr, Wallet: walletResponse.Data, PageAttr: pg, Transactions: txs.Data}
+		History      historyPage
+		Contacts     interface{}
+		Watch        interface{}
+		Withdrawals  interface{}
+		Admin        bool
+		RequestKey   string
+	}{User: *usr, Wallet: walletResponse.Data, PageAttr: pg, Transactions: txs.Data,
+		History: newHistoryPage(filter, txs), Contacts: contacts.Data["contacts"],
+		Watch: watch.Data["watch"], Withdrawals: withdrawals.Data["withdrawals"], Admin: admins[usr.Username],
+		RequestKey: newRequestKey()}
 	InternalServerError(res, req, templates.ExecuteTemplate(res, "account.html", data))
 }
 
@@ -212,13 +284,22 @@ func signupHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Para
 	username := req.FormValue("username")
 	password := req.FormValue("password")
 	verifyPassword := req.FormValue("verify_password")
+	wallet := url.Values{
+		"spend_key":   {strings.TrimSpace(req.FormValue("spend_key"))},
+		"mnemonic":    {strings.TrimSpace(req.FormValue("mnemonic"))},
+		"scan_height": {strings.TrimSpace(req.FormValue("scan_height"))},
+	}
+	importing := wallet.Get("spend_key") != "" || wallet.Get("mnemonic") != ""
 
 	if len(username) < 1 || len(password) < 1 || len(username) > 64 {
 		message = "Incorrect Username/Password format"
 	} else if password != verifyPassword {
 		message = "Passwords do not match"
-	} else if response := tryAuth(username, password, "signup"); response.Status != "OK" {
+	} else if response := trySignup(username, password, wallet); response.Status != "OK" {
 		message = "Could not create account. Try again"
+		if importing {
+			message = "Could not import wallet: " + response.Status
+		}
 	}
 
 	if message != "" {
@@ -246,36 +327,87 @@ func getWalletInfo(res http.ResponseWriter, req *http.Request, _ httprouter.Para
 	}
 }
 
-// sendHandler - sends a transaction
+// validateAddressHandler - decodes the destination typed in the send form,
+// answers with the status as the error message to show
+func validateAddressHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	address := strings.TrimSpace(req.FormValue("address"))
+	if address == "" {
+		json.NewEncoder(res).Encode(jsonResponse{Status: "Enter a destination address"})
+		return
+	}
+	json.NewEncoder(res).Encode(walletCmd("validate_address", url.PathEscape(address)))
+}
+
+// sendHandler - builds the transaction in the wallet service and shows it for review
 func sendHandler(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
 	if !alreadyLoggedIn(res, req) {
 		http.Redirect(res, req, hostURI, http.StatusSeeOther)
 		return
 	}
-	var message string
 	usr := sessionGetKeys(req, "session")
 	if usr == nil {
 		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
 		return
 	}
+	if refuseSend(res, req, usr.Address) {
+		return
+	}
 
-	resb, err := http.PostForm(walletURI+"/send_transaction",
-		url.Values{
-			"amount":      {req.FormValue("amount")},
-			"address":     {usr.Address},
-			"destination": {strings.TrimSpace(req.FormValue("destination"))},
-			"payment_id":  {req.FormValue("payment_id")},
+	response := walletPost("delayed_transaction", url.Values{
+		"amount":      {req.FormValue("amount")},
+		"address":     {usr.Address},
+		"destination": {strings.TrimSpace(req.FormValue("destination"))},
+		"payment_id":  {req.FormValue("payment_id")},
+		"request_key": {req.FormValue("request_key")},
+	})
+	if response.Status != "OK" {
+		http.SetCookie(res, &http.Cookie{
+			Name:  "transactionHash",
+			Path:  "/account",
+			Value: "Error!: " + response.Status,
 		})
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		http.Redirect(res, req, hostURI+"/account", http.StatusSeeOther)
+		return
+	}
+	data := struct {
+		User     userInfo
+		PageAttr pageInfo
+		Review   interface{}
+	}{User: *usr, PageAttr: pageInfo{URI: hostURI}, Review: response.Data["review"]}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "review.html", data))
+}
+
+// confirmHandler - sends a reviewed transaction
+func confirmHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	var message string
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	if refuseSend(res, req, usr.Address) {
 		return
 	}
-	response := jsonResponse{}
-	json.NewDecoder(resb.Body).Decode(&response)
+	response := walletPost("delayed_transaction/send", url.Values{
+		"address": {usr.Address},
+		"hash":    {req.FormValue("hash")},
+	})
 	if response.Status != "OK" {
 		message = "Error!: " + response.Status
+	} else if _, held := response.Data["held"]; held {
+		message = "Held for approval, the outcome is listed under Withdrawals"
+	} else if hash, ok := response.Data["transactionHash"].(string); ok {
+		message = hash
 	} else {
-		message = response.Data["result"].(map[string]interface{})["transactionHash"].(string)
+		message = "Error!: The wallet did not return a transaction hash, check your transaction history"
 	}
 	c := &http.Cookie{
 		Name:  "transactionHash",
@@ -286,6 +418,45 @@ func sendHandler(res http.ResponseWriter, req *http.Request, p httprouter.Params
 	http.Redirect(res, req, hostURI+"/account", http.StatusSeeOther)
 }
 
+// cancelHandler - discards a reviewed transaction
+func cancelHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	walletPost("delayed_transaction/delete", url.Values{
+		"address": {usr.Address},
+		"hash":    {req.FormValue("hash")},
+	})
+	http.Redirect(res, req, hostURI+"/account", http.StatusSeeOther)
+}
+
+// optimizeHandler - consolidates the outputs of the user's wallet
+func optimizeHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("optimize", url.Values{"address": {usr.Address}})
+	message := "Error!: " + response.Status
+	if response.Status == "OK" {
+		hashes, _ := response.Data["transactionHashes"].([]interface{})
+		message = "Sent " + strconv.Itoa(len(hashes)) + " optimization transaction(s)"
+	}
+	http.SetCookie(res, &http.Cookie{Name: "optimizeMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account", http.StatusSeeOther)
+}
+
 // keyHandler - shows the wallet keys of a user
 func keyHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	if !alreadyLoggedIn(res, req) {
@@ -326,15 +497,57 @@ func walletKeys(res http.ResponseWriter, req *http.Request, _ httprouter.Params)
 	http.SetCookie(res, &http.Cookie{Name: "key", Path: "/account/keys", MaxAge: -1})
 
 	keys := walletCmd("export_keys", usr.Address)
+	seed, _ := keys.Data["mnemonicSeed"].(string)
+	quizSize, _ := keys.Data["quizSize"].(float64)
+	quiz := quizPositions(int(quizSize), len(strings.Fields(seed)))
+	if quiz != nil {
+		if err := sessionSetQuiz(req, quiz); err != nil {
+			log.Println("Error: seed quiz:", err)
+			quiz = nil
+		}
+	}
 
 	data := struct {
-		User userInfo
-		Keys map[string]interface{}
-	}{User: userInfo{Username: usr.Username}, Keys: keys.Data}
+		User     userInfo
+		Keys     map[string]interface{}
+		PageAttr pageInfo
+		Seed     []string
+		Quiz     []int
+	}{User: userInfo{Username: usr.Username}, Keys: keys.Data, PageAttr: pageInfo{URI: hostURI},
+		Seed: strings.Fields(seed), Quiz: quiz}
 	err := templates.ExecuteTemplate(res, "keys.html", data)
 	InternalServerError(res, req, err)
 }
 
+// seedBackupHandler - checks the words of the seed quiz and marks the seed
+// as backed up
+func seedBackupHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	req.ParseForm()
+	// the positions drawn for the keys page, not whichever the form names
+	positions := sessionTakeQuiz(req)
+	message := "Mnemonic seed marked as backed up"
+	if positions == nil {
+		message = "Error!: No backup quiz to check. Export your keys again to retry"
+	} else if response := walletPost("seed_backup", url.Values{
+		"address":  {usr.Address},
+		"position": positions,
+		"word":     req.Form["word"],
+	}); response.Status != "OK" {
+		message = "Error!: " + response.Status + ". Export your keys again to retry"
+	}
+	http.SetCookie(res, &http.Cookie{Name: "seedMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account", http.StatusSeeOther)
+}
+
 // terms - shows the terms of service
 func terms(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	err := templates.ExecuteTemplate(res, "terms.html", nil)
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// healthHealthy - the only walletd health state in which sends are allowed
+const healthHealthy = "healthy"
+
+// healthMessages - banner shown on the account page for the other states
+var healthMessages = map[string]string{
+	"syncing":  "The wallet is catching up with the network. Balances may be out of date and sending is disabled until it is synced.",
+	"degraded": "The wallet is not responding reliably. Sending is disabled until it recovers.",
+	"down":     "The wallet is unavailable. Sending is disabled until it is back.",
+}
+
+// walletHealth - health of the walletd backend holding address, down when
+// the wallet service can't tell
+func walletHealth(address string) string {
+	response := walletCmd("health", address)
+	state, _ := response.Data["state"].(string)
+	if response.Status != "OK" || (state != healthHealthy && healthMessages[state] == "") {
+		log.Println("Error: wallet health:", response.Status)
+		return "down"
+	}
+	return state
+}
+
+// refuseSend - sends the user back to the account page when the wallet of
+// address can't send right now, reports whether it did
+func refuseSend(res http.ResponseWriter, req *http.Request, address string) bool {
+	state := walletHealth(address)
+	if state == healthHealthy {
+		return false
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:  "transactionHash",
+		Path:  "/account",
+		Value: "Error!: " + healthMessages[state],
+	})
+	http.Redirect(res, req, hostURI+"/account", http.StatusSeeOther)
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// historyFilters - query parameters of the account page passed on to the
+// wallet service to filter the transaction history
+var historyFilters = []string{"direction", "min", "max", "payment_id", "hash", "from", "to"}
+
+// historyPage - the filters of the history shown on the account page and
+// the links to its neighbouring pages
+type historyPage struct {
+	Filter   map[string]string
+	Filtered bool
+	Next     string
+	Prev     string
+	Error    string
+}
+
+// historyQuery - the filters set on the account page request
+func historyQuery(req *http.Request) url.Values {
+	query := url.Values{}
+	for _, name := range historyFilters {
+		if v := req.FormValue(name); v != "" {
+			query.Set(name, v)
+		}
+	}
+	return query
+}
+
+// newHistoryPage - describes the page of history returned by the wallet
+// service for filter
+func newHistoryPage(filter url.Values, response *jsonResponse) historyPage {
+	page := historyPage{Filter: map[string]string{}, Filtered: len(filter) > 0}
+	for name := range filter {
+		page.Filter[name] = filter.Get(name)
+	}
+	if response.Status != "OK" {
+		page.Error = response.Status
+		return page
+	}
+	link := func(cursor interface{}) string {
+		c, _ := cursor.(string)
+		if c == "" {
+			return ""
+		}
+		query := url.Values{"cursor": {c}}
+		for name := range filter {
+			query.Set(name, filter.Get(name))
+		}
+		return hostURI + "/account?" + query.Encode() + "#history"
+	}
+	page.Next = link(response.Data["next"])
+	page.Prev = link(response.Data["prev"])
+	return page
+}
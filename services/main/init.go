@@ -3,6 +3,7 @@ package main
 import (
 	"html/template"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -21,6 +22,7 @@ var (
 	ratelimiter, strictRL *stdlib.Middleware
 	templates             *template.Template
 	logFile               *os.File
+	admins                = map[string]bool{} // users who decide held withdrawals
 )
 
 func init() {
@@ -49,6 +51,12 @@ func init() {
 		panic("Set the WALLET_URI env variable")
 	}
 
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins[name] = true
+		}
+	}
+
 	// logging setup
 	logFile, err = os.OpenFile("service.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -66,5 +74,4 @@ func init() {
 
 	templates = template.Must(template.ParseGlob("templates/*.html"))
 	sessionDB = newPool(redisHost)
-	cleanupHook()
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// integratedPage - lists the integrated addresses of the user
+func integratedPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletCmd("integrated_addresses", usr.Address)
+	if response.Status != "OK" {
+		http.Error(res, "Error loading integrated addresses", http.StatusInternalServerError)
+		return
+	}
+
+	pg := pageInfo{URI: hostURI, Messages: map[string]interface{}{}}
+	if msg, err := req.Cookie("integratedAddress"); err == nil {
+		if strings.HasPrefix(msg.Value, "Error!: ") {
+			pg.Messages["error"] = msg.Value
+		} else {
+			pg.Messages["integratedAddress"] = msg.Value
+		}
+		http.SetCookie(res, &http.Cookie{Name: "integratedAddress", Path: "/account", MaxAge: -1})
+	}
+
+	data := struct {
+		User      userInfo
+		PageAttr  pageInfo
+		Addresses interface{}
+	}{User: *usr, PageAttr: pg, Addresses: response.Data["integratedAddresses"]}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "integrated.html", data))
+}
+
+// integratedHandler - generates an integrated address for the user
+func integratedHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	var message string
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+
+	response := walletPost("integrated_address", url.Values{
+		"address":    {usr.Address},
+		"payment_id": {strings.TrimSpace(req.FormValue("payment_id"))},
+		"label":      {req.FormValue("label")},
+	})
+	if response.Status != "OK" {
+		message = "Error!: " + response.Status
+	} else {
+		message = response.Data["integratedAddress"].(string)
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:  "integratedAddress",
+		Path:  "/account",
+		Value: message,
+	})
+	http.Redirect(res, req, hostURI+"/account/integrated_addresses", http.StatusSeeOther)
+}
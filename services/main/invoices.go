@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const invoiceIDFormat = "^[a-f0-9]{32}$"
+
+// invoicesPage - lists the invoices of the user
+func invoicesPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletCmd("invoices", usr.Address)
+	if response.Status != "OK" {
+		http.Error(res, "Error loading invoices", http.StatusInternalServerError)
+		return
+	}
+
+	pg := pageInfo{URI: hostURI, Messages: map[string]interface{}{}}
+	if msg, err := req.Cookie("invoiceMessage"); err == nil {
+		if strings.HasPrefix(msg.Value, "Error!: ") {
+			pg.Messages["error"] = msg.Value
+		} else {
+			pg.Messages["invoice"] = msg.Value
+		}
+		http.SetCookie(res, &http.Cookie{Name: "invoiceMessage", Path: "/account", MaxAge: -1})
+	}
+
+	data := struct {
+		User     userInfo
+		PageAttr pageInfo
+		Invoices interface{}
+	}{User: *usr, PageAttr: pg, Invoices: response.Data["invoices"]}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "invoices.html", data))
+}
+
+// invoiceHandler - creates an invoice for the user
+func invoiceHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("invoices", url.Values{
+		"address":     {usr.Address},
+		"amount":      {strings.TrimSpace(req.FormValue("amount"))},
+		"description": {req.FormValue("description")},
+		"expires_in":  {req.FormValue("expires_in")},
+	})
+	message := "Error!: " + response.Status
+	if response.Status == "OK" {
+		message = hostURI + "/invoice/" + response.Data["id"].(string)
+	}
+	http.SetCookie(res, &http.Cookie{Name: "invoiceMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account/invoices", http.StatusSeeOther)
+}
+
+// invoicePage - public page of an invoice, no login required
+func invoicePage(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	if matched, _ := regexp.MatchString(invoiceIDFormat, p.ByName("id")); !matched {
+		http.NotFound(res, req)
+		return
+	}
+	response := walletCmd("invoice", p.ByName("id"))
+	if response.Status != "OK" {
+		http.NotFound(res, req)
+		return
+	}
+	data := struct {
+		PageAttr pageInfo
+		Invoice  interface{}
+	}{PageAttr: pageInfo{URI: hostURI}, Invoice: response.Data["invoice"]}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "invoice.html", data))
+}
@@ -32,5 +32,10 @@ func main() {
 	log.Fatal(srv.ListenAndServeTLS("fullchain.pem", "privkey.pem"))
 	*/
 	log.Println("Info: Starting Service on:", hostURI)
-	log.Fatal(srv.ListenAndServe())
+	done := shutdownOnSignal(srv)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	log.Println("Info: Service stopped")
 }
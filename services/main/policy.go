@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// policyPage - shows the spending limits and allowlist of the user
+func policyPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletCmd("policy", usr.Address)
+	if response.Status != "OK" {
+		http.Error(res, "Error loading spending limits", http.StatusInternalServerError)
+		return
+	}
+
+	pg := pageInfo{URI: hostURI, Messages: map[string]interface{}{}}
+	if msg, err := req.Cookie("policyMessage"); err == nil {
+		if strings.HasPrefix(msg.Value, "Error!: ") {
+			pg.Messages["error"] = msg.Value
+		} else {
+			pg.Messages["success"] = msg.Value
+		}
+		http.SetCookie(res, &http.Cookie{Name: "policyMessage", Path: "/account", MaxAge: -1})
+	}
+
+	data := struct {
+		User     userInfo
+		PageAttr pageInfo
+		Policy   map[string]interface{}
+	}{User: *usr, PageAttr: pg, Policy: response.Data}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "policy.html", data))
+}
+
+// policyHandler - saves the spending limits of the user
+func policyHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	policyAction(res, req, "policy", url.Values{
+		"daily_limit":    {req.FormValue("daily_limit")},
+		"weekly_limit":   {req.FormValue("weekly_limit")},
+		"tx_limit":       {req.FormValue("tx_limit")},
+		"allowlist_only": {req.FormValue("allowlist_only")},
+	}, func(response *jsonResponse) string {
+		if pending, ok := response.Data["pending"].(map[string]interface{}); ok {
+			effective, _ := pending["Effective"].(string)
+			return "Stricter limits apply now, the rest from " + effective
+		}
+		return "Spending limits saved"
+	})
+}
+
+// policyCancelHandler - drops the pending change of the spending limits
+func policyCancelHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	policyAction(res, req, "policy/cancel", url.Values{}, func(*jsonResponse) string {
+		return "Pending change cancelled"
+	})
+}
+
+// allowHandler - adds a destination to the allowlist
+func allowHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	policyAction(res, req, "policy/allow", url.Values{
+		"destination": {req.FormValue("destination")},
+		"label":       {req.FormValue("label")},
+	}, func(response *jsonResponse) string {
+		effective, _ := response.Data["effective"].(string)
+		return "Destination allowed from " + effective
+	})
+}
+
+// allowDeleteHandler - removes a destination from the allowlist
+func allowDeleteHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	policyAction(res, req, "policy/allow/delete", url.Values{"id": {req.FormValue("id")}},
+		func(*jsonResponse) string {
+			return "Destination removed"
+		})
+}
+
+// policyAction - posts a change of the spending policy for the user and
+// goes back to the policy page with the outcome
+func policyAction(res http.ResponseWriter, req *http.Request, cmd string, form url.Values,
+	success func(*jsonResponse) string) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	form.Set("address", usr.Address)
+	response := walletPost(cmd, form)
+	message := "Error!: " + response.Status
+	if response.Status == "OK" {
+		message = success(response)
+	}
+	http.SetCookie(res, &http.Cookie{Name: "policyMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account/policy", http.StatusSeeOther)
+}
@@ -3,4 +3,4 @@ HOST_URI='http://localhost' \
 HOST_PORT=':8080' \
 USER_URI='http://localhost:8081' \
 WALLET_URI='http://localhost:8082' \
-go run main.go init.go handlers.go utils.go
+go run main.go init.go handlers.go utils.go integrated.go batch.go webhooks.go invoices.go contacts.go export.go history.go health.go watch.go policy.go admin.go
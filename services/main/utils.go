@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"log"
+	"math/big"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -35,6 +42,11 @@ type pageInfo struct {
 
 // limit - rate limiter middleware
 func limit(h httprouter.Handle, rl *stdlib.Middleware) httprouter.Handle {
+	return limitBody(h, rl, 2048)
+}
+
+// limitBody - rate limiter middleware allowing post bodies up to maxBytes
+func limitBody(h httprouter.Handle, rl *stdlib.Middleware, maxBytes int64) httprouter.Handle {
 	return func(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
 		context, err := rl.Limiter.Get(req.Context(), rl.Limiter.GetIPKey(req))
 		if err != nil {
@@ -51,7 +63,7 @@ func limit(h httprouter.Handle, rl *stdlib.Middleware) httprouter.Handle {
 			return
 		}
 		res.Header().Set("Access-Control-Allow-Origin", "*")
-		req.Body = http.MaxBytesReader(res, req.Body, 2048) // limit post size
+		req.Body = http.MaxBytesReader(res, req.Body, maxBytes) // limit post size
 		h(res, req, p)
 	}
 }
@@ -136,6 +148,40 @@ func sessionGetKeys(req *http.Request, name string) *userInfo {
 	return data
 }
 
+// sessionSetQuiz - stores the seed quiz positions shown to the session, the
+// backup is only checked against these
+func sessionSetQuiz(req *http.Request, positions []int) error {
+	cookie, err := req.Cookie("session")
+	if err != nil {
+		return err
+	}
+	quiz := make([]string, len(positions))
+	for i, p := range positions {
+		quiz[i] = strconv.Itoa(p)
+	}
+	conn := sessionDB.Get()
+	defer conn.Close()
+	_, err = conn.Do("HSET", cookie.Value, "seed_quiz", strings.Join(quiz, ","))
+	return err
+}
+
+// sessionTakeQuiz - the seed quiz positions of the session, removed so each
+// quiz is answered once
+func sessionTakeQuiz(req *http.Request) []string {
+	cookie, err := req.Cookie("session")
+	if err != nil {
+		return nil
+	}
+	conn := sessionDB.Get()
+	defer conn.Close()
+	quiz, err := redis.String(conn.Do("HGET", cookie.Value, "seed_quiz"))
+	if err != nil || quiz == "" {
+		return nil
+	}
+	conn.Do("HDEL", cookie.Value, "seed_quiz")
+	return strings.Split(quiz, ",")
+}
+
 // sessionDelKey - wrapper for redis DEL KEY - used for logout
 func sessionDelKey(key string) error {
 	conn := sessionDB.Get()
@@ -159,6 +205,22 @@ func tryAuth(username, password, method string) *jsonResponse {
 	return response
 }
 
+// trySignup - signs up with a given username and password, importing the
+// wallet given by the spend_key or mnemonic field of wallet if any
+func trySignup(username, password string, wallet url.Values) *jsonResponse {
+	wallet.Set("username", username)
+	wallet.Set("password", password)
+	resb, err := http.PostForm(usrURI+"/signup", wallet)
+	if err != nil {
+		return &jsonResponse{Status: err.Error()}
+	}
+	response, err := decodeResponse(resb)
+	if err != nil {
+		return &jsonResponse{Status: err.Error()}
+	}
+	return response
+}
+
 // walletCmd - executes a wallet command and returns the result
 func walletCmd(cmd, param string) *jsonResponse {
 	response := jsonResponse{}
@@ -166,13 +228,78 @@ func walletCmd(cmd, param string) *jsonResponse {
 	if err != nil {
 		return &jsonResponse{Status: err.Error()}
 	}
+	defer resb.Body.Close()
 	if err = json.NewDecoder(resb.Body).Decode(&response); err != nil {
 		return &jsonResponse{Status: err.Error()}
 	}
-	response.Status = "OK"
 	return &response
 }
 
+// walletPost - posts a form to a wallet command and returns the result
+func walletPost(cmd string, form url.Values) *jsonResponse {
+	response := jsonResponse{}
+	resb, err := http.PostForm(walletURI+"/"+cmd, form)
+	if err != nil {
+		return &jsonResponse{Status: err.Error()}
+	}
+	defer resb.Body.Close()
+	if err = json.NewDecoder(resb.Body).Decode(&response); err != nil {
+		return &jsonResponse{Status: err.Error()}
+	}
+	return &response
+}
+
+// mergePending - puts pending transactions in front of the mined ones,
+// skipping any that were mined since the pending list was fetched
+func mergePending(pending, mined interface{}) []interface{} {
+	minedList, _ := mined.([]interface{})
+	pendingList, _ := pending.([]interface{})
+	hashes := map[interface{}]bool{}
+	for _, tx := range minedList {
+		if m, ok := tx.(map[string]interface{}); ok {
+			hashes[m["Hash"]] = true
+		}
+	}
+	merged := []interface{}{}
+	for _, tx := range pendingList {
+		m, ok := tx.(map[string]interface{})
+		if ok && !hashes[m["Hash"]] {
+			merged = append(merged, tx)
+		}
+	}
+	return append(merged, minedList...)
+}
+
+// newRequestKey - random key identifying a send or a batch, lets the wallet
+// service recognise retried submissions of the same form
+func newRequestKey() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// quizPositions - n different random word positions out of 1 to count,
+// in order
+func quizPositions(n, count int) []int {
+	if n > count {
+		return nil
+	}
+	taken := map[int]bool{}
+	positions := make([]int, 0, n)
+	for len(positions) < n {
+		r, err := rand.Int(rand.Reader, big.NewInt(int64(count)))
+		if err != nil {
+			return nil
+		}
+		if p := int(r.Int64()) + 1; !taken[p] {
+			taken[p] = true
+			positions = append(positions, p)
+		}
+	}
+	sort.Ints(positions)
+	return positions
+}
+
 // walletStatusColor - green if synced, else orange
 func walletStatusColor(res *jsonResponse) string {
 	a := res.Data["status"].(map[string]interface{})["knownBlockCount"].(float64)
@@ -214,15 +341,25 @@ func newPool(server string) *redis.Pool {
 	}
 }
 
-// cleanipHook - close redis pool on exit
-func cleanupHook() {
+// shutdownTimeout - how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// shutdownOnSignal - on SIGINT or SIGTERM stops accepting requests, waits
+// for the ones in flight and closes the redis pool. The returned channel is
+// closed once it is safe to exit.
+func shutdownOnSignal(srv *http.Server) <-chan struct{} {
+	done := make(chan struct{})
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	signal.Notify(c, syscall.SIGTERM)
-	signal.Notify(c, syscall.SIGKILL)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-c
+		log.Println("Info: Shutting down on", <-c)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("Error: shutdown:", err)
+		}
 		sessionDB.Close()
-		os.Exit(0)
+		close(done)
 	}()
+	return done
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// watchPage - shows the watch-only addresses of the user
+func watchPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletCmd("watch", usr.Address)
+	if response.Status != "OK" {
+		http.Error(res, "Error loading watch-only addresses", http.StatusInternalServerError)
+		return
+	}
+
+	pg := pageInfo{URI: hostURI, Messages: map[string]interface{}{}}
+	if msg, err := req.Cookie("watchMessage"); err == nil {
+		if strings.HasPrefix(msg.Value, "Error!: ") {
+			pg.Messages["error"] = msg.Value
+		} else {
+			pg.Messages["success"] = msg.Value
+		}
+		http.SetCookie(res, &http.Cookie{Name: "watchMessage", Path: "/account", MaxAge: -1})
+	}
+
+	data := struct {
+		User     userInfo
+		PageAttr pageInfo
+		Watch    interface{}
+	}{User: *usr, PageAttr: pg, Watch: response.Data["watch"]}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "watch.html", data))
+}
+
+// watchHandler - adds a watch-only address from its public spend key and
+// private view key
+func watchHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("watch", url.Values{
+		"address":          {usr.Address},
+		"watch_address":    {req.FormValue("watch_address")},
+		"spend_public_key": {req.FormValue("spend_public_key")},
+		"view_key":         {req.FormValue("view_key")},
+		"label":            {req.FormValue("label")},
+		"scan_height":      {strings.TrimSpace(req.FormValue("scan_height"))},
+	})
+	message := "Watch-only address added"
+	if response.Status != "OK" {
+		message = "Error!: " + response.Status
+	}
+	http.SetCookie(res, &http.Cookie{Name: "watchMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account/watch", http.StatusSeeOther)
+}
+
+// watchDeleteHandler - stops watching an address
+func watchDeleteHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("watch/delete", url.Values{
+		"address":       {usr.Address},
+		"watch_address": {req.FormValue("watch_address")},
+	})
+	message := "Watch-only address removed"
+	if response.Status != "OK" {
+		message = "Error!: " + response.Status
+	}
+	http.SetCookie(res, &http.Cookie{Name: "watchMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account/watch", http.StatusSeeOther)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// webhooksPage - lists the webhooks of the user and their deliveries
+func webhooksPage(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletCmd("webhooks", usr.Address)
+	if response.Status != "OK" {
+		http.Error(res, "Error loading webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	pg := pageInfo{URI: hostURI, Messages: map[string]interface{}{}}
+	if msg, err := req.Cookie("webhookMessage"); err == nil {
+		if strings.HasPrefix(msg.Value, "Error!: ") {
+			pg.Messages["error"] = msg.Value
+		} else {
+			pg.Messages["success"] = msg.Value
+		}
+		http.SetCookie(res, &http.Cookie{Name: "webhookMessage", Path: "/account", MaxAge: -1})
+	}
+
+	data := struct {
+		User     userInfo
+		PageAttr pageInfo
+		Webhooks interface{}
+	}{User: *usr, PageAttr: pg, Webhooks: response.Data["webhooks"]}
+	InternalServerError(res, req, templates.ExecuteTemplate(res, "webhooks.html", data))
+}
+
+// webhookHandler - registers a webhook
+func webhookHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("webhooks", url.Values{
+		"address":       {usr.Address},
+		"url":           {req.FormValue("url")},
+		"confirmations": {req.FormValue("confirmations")},
+	})
+	message := "Webhook added"
+	if response.Status != "OK" {
+		message = "Error!: " + response.Status
+	}
+	http.SetCookie(res, &http.Cookie{Name: "webhookMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account/webhooks", http.StatusSeeOther)
+}
+
+// webhookDeleteHandler - removes a webhook
+func webhookDeleteHandler(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !alreadyLoggedIn(res, req) {
+		http.Redirect(res, req, hostURI, http.StatusSeeOther)
+		return
+	}
+	usr := sessionGetKeys(req, "session")
+	if usr == nil {
+		http.Error(res, "Couldn't find user session", http.StatusInternalServerError)
+		return
+	}
+	response := walletPost("webhooks/delete", url.Values{
+		"address": {usr.Address},
+		"id":      {req.FormValue("id")},
+	})
+	message := "Webhook removed"
+	if response.Status != "OK" {
+		message = "Error!: " + response.Status
+	}
+	http.SetCookie(res, &http.Cookie{Name: "webhookMessage", Path: "/account", Value: message})
+	http.Redirect(res, req, hostURI+"/account/webhooks", http.StatusSeeOther)
+}
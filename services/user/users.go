@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 
 	_ "github.com/lib/pq"
@@ -68,7 +69,12 @@ func main() {
 	router.POST("/signup", signup)
 	router.POST("/login", login)
 	router.GET("/delete/:username", deleteUser)
-	log.Fatal(http.ListenAndServe(hostPort, router))
+	srv := &http.Server{Addr: hostPort, Handler: router}
+	done := shutdownOnSignal(srv)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
 }
 
 // signup - adds user to db
@@ -88,13 +94,11 @@ func signup(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 		return
 	}
 	ih, verif := v.Encode()
-	resb, err := http.Get(walletURI + "/create")
+	address, err := walletAddress(req)
 	if err != nil {
 		encoder.Encode(jsonResponse{Status: err.Error()})
 		return
 	}
-	response, err := decodeResponse(resb)
-	address := response["Data"].(map[string]interface{})["address"].(string)
 	_, err = db.Exec("INSERT INTO accounts (ih, verifier, username, address) VALUES ($1, $2, $3, $4);", ih, verif, username, address)
 	if err != nil {
 		encoder.Encode(jsonResponse{Status: err.Error()})
@@ -103,6 +107,35 @@ func signup(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	}
 }
 
+// walletAddress - creates the address of a new account, or imports the
+// existing wallet given by a spend key or mnemonic seed
+func walletAddress(req *http.Request) (string, error) {
+	var resb *http.Response
+	var err error
+	if req.FormValue("spend_key") != "" || req.FormValue("mnemonic") != "" {
+		resb, err = http.PostForm(walletURI+"/import", url.Values{
+			"spend_key":   {req.FormValue("spend_key")},
+			"mnemonic":    {req.FormValue("mnemonic")},
+			"scan_height": {req.FormValue("scan_height")},
+		})
+	} else {
+		resb, err = http.Get(walletURI + "/create")
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resb.Body.Close()
+	response, err := decodeResponse(resb)
+	if err != nil {
+		return "", err
+	}
+	if response["Status"] != "OK" {
+		return "", fmt.Errorf("%v", response["Status"])
+	}
+	address, _ := response["Data"].(map[string]interface{})["address"].(string)
+	return address, nil
+}
+
 // login - verify username/password and sends back a sessionID
 func login(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
 	encoder := json.NewEncoder(res)
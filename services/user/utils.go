@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout - how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 30 * time.Second
+
 type jsonResponse struct {
 	Status string
 	Data   map[string]interface{}
@@ -57,3 +65,23 @@ func getUser(username string) (*user, error) {
 	}
 	return &usr, nil
 }
+
+// shutdownOnSignal - on SIGINT or SIGTERM stops accepting requests, waits
+// for the ones in flight and closes the database. The returned channel is
+// closed once it is safe to exit.
+func shutdownOnSignal(srv *http.Server) <-chan struct{} {
+	done := make(chan struct{})
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		fmt.Println("shutdown:", <-c)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Println("shutdown:", err)
+		}
+		db.Close()
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	base58Alphabet  = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	base58BlockSize = 8  // bytes encoded per full block
+	base58BlockLen  = 11 // characters of a full block
+
+	addressKeysSize      = 64 // public spend key and public view key
+	addressPaymentIDSize = 64 // hex characters of an embedded payment id
+	addressChecksumSize  = 4
+)
+
+// base58BlockLens - characters encoding a block of as many bytes as the index
+var base58BlockLens = [base58BlockSize + 1]int{0, 2, 3, 5, 6, 7, 9, 10, 11}
+
+var (
+	errAddressFormat   = errors.New("Incorrect Address Format")
+	errAddressPrefix   = errors.New("Address is not a TRTL address")
+	errAddressChecksum = errors.New("Address checksum does not match, check it for typos")
+)
+
+// cnAddress - the keys an address is made of, and the payment id embedded
+// in integrated addresses
+type cnAddress struct {
+	SpendPublicKey string
+	ViewPublicKey  string
+	PaymentID      string // empty for standard addresses
+}
+
+// integrated - whether the address embeds a payment id
+func (a *cnAddress) integrated() bool {
+	return a.PaymentID != ""
+}
+
+// standard - the standard address of the keys, without the payment id
+func (a *cnAddress) standard() string {
+	address, _ := encodeAddress(a.SpendPublicKey, a.ViewPublicKey, "")
+	return address
+}
+
+// encodeAddress - the address of the public keys, integrated if paymentID
+// is given
+func encodeAddress(spendPublicKey, viewPublicKey, paymentID string) (string, error) {
+	spend, err := hex.DecodeString(spendPublicKey)
+	if err != nil || len(spend) != 32 {
+		return "", errors.New("Public spend key must be 64 hex characters")
+	}
+	view, err := hex.DecodeString(viewPublicKey)
+	if err != nil || len(view) != 32 {
+		return "", errors.New("Public view key must be 64 hex characters")
+	}
+	if matched, _ := regexp.MatchString(paymentIDFormat, paymentID); !matched && paymentID != "" {
+		return "", errors.New("Incorrect Payment ID Format")
+	}
+	data := make([]byte, binary.MaxVarintLen64)
+	data = data[:binary.PutUvarint(data, addressPrefix)]
+	// integrated addresses carry the payment id as its hex characters
+	data = append(data, strings.ToLower(paymentID)...)
+	data = append(data, spend...)
+	data = append(data, view...)
+	sum := keccak256(data)
+	return encodeBase58(append(data, sum[:addressChecksumSize]...)), nil
+}
+
+// decodeAddress - the keys and payment id of a TRTL address, refused if
+// its prefix or checksum don't match
+func decodeAddress(address string) (*cnAddress, error) {
+	data, err := decodeBase58(address)
+	if err != nil {
+		return nil, err
+	}
+	prefix, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errAddressFormat
+	}
+	if prefix != addressPrefix {
+		return nil, errAddressPrefix
+	}
+	body := data[n:]
+	if len(body) != addressKeysSize+addressChecksumSize &&
+		len(body) != addressPaymentIDSize+addressKeysSize+addressChecksumSize {
+		return nil, errAddressFormat
+	}
+	sum := keccak256(data[:len(data)-addressChecksumSize])
+	if !bytes.Equal(sum[:addressChecksumSize], data[len(data)-addressChecksumSize:]) {
+		return nil, errAddressChecksum
+	}
+	addr := &cnAddress{}
+	if len(body) > addressKeysSize+addressChecksumSize {
+		addr.PaymentID = string(body[:addressPaymentIDSize])
+		if matched, _ := regexp.MatchString(paymentIDFormat, addr.PaymentID); !matched {
+			return nil, errors.New("Address embeds an incorrect payment ID")
+		}
+		addr.PaymentID = strings.ToLower(addr.PaymentID)
+		body = body[addressPaymentIDSize:]
+	}
+	addr.SpendPublicKey = hex.EncodeToString(body[:32])
+	addr.ViewPublicKey = hex.EncodeToString(body[32:addressKeysSize])
+	return addr, nil
+}
+
+// checkDestination - decodes the destination of a send, whose payment id
+// can't be given again if it is an integrated address
+func checkDestination(dest, paymentID string) (*cnAddress, error) {
+	addr, err := decodeAddress(dest)
+	if err != nil {
+		return nil, err
+	}
+	if addr.integrated() && paymentID != "" {
+		return nil, errors.New("Integrated addresses carry their own payment ID, leave it empty")
+	}
+	return addr, nil
+}
+
+// encodeBase58 - CryptoNote base58, which encodes blocks of 8 bytes into 11
+// characters each instead of the whole data as one number
+func encodeBase58(data []byte) string {
+	var buf bytes.Buffer
+	for len(data) > 0 {
+		size := base58BlockSize
+		if len(data) < size {
+			size = len(data)
+		}
+		var num uint64
+		for _, b := range data[:size] {
+			num = num<<8 | uint64(b)
+		}
+		block := make([]byte, base58BlockLens[size])
+		for i := len(block) - 1; i >= 0; i-- {
+			block[i] = base58Alphabet[num%58]
+			num /= 58
+		}
+		buf.Write(block)
+		data = data[size:]
+	}
+	return buf.String()
+}
+
+// decodeBase58 - the data of a CryptoNote base58 string
+func decodeBase58(s string) ([]byte, error) {
+	data := make([]byte, 0, len(s)/base58BlockLen*base58BlockSize+base58BlockSize)
+	for len(s) > 0 {
+		n := base58BlockLen
+		if len(s) < n {
+			n = len(s)
+		}
+		size := -1
+		for i, l := range base58BlockLens {
+			if l == n {
+				size = i
+			}
+		}
+		if size <= 0 {
+			return nil, errAddressFormat
+		}
+		var num uint64
+		for i := 0; i < n; i++ {
+			digit := strings.IndexByte(base58Alphabet, s[i])
+			if digit < 0 || num > (^uint64(0)-uint64(digit))/58 {
+				return nil, errAddressFormat
+			}
+			num = num*58 + uint64(digit)
+		}
+		if size < base58BlockSize && num>>(8*uint(size)) != 0 {
+			return nil, errAddressFormat
+		}
+		for i := size - 1; i >= 0; i-- {
+			data = append(data, byte(num>>(8*uint(i))))
+		}
+		s = s[n:]
+	}
+	return data, nil
+}
+
+// validateAddress - decodes an address for the send form, returning its
+// standard address and the payment id of integrated ones
+func validateAddress(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	addr, err := decodeAddress(strings.TrimSpace(p.ByName("address")))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{
+		"address":        addr.standard(),
+		"integrated":     addr.integrated(),
+		"paymentID":      addr.PaymentID,
+		"spendPublicKey": addr.SpendPublicKey,
+		"viewPublicKey":  addr.ViewPublicKey,
+	}})
+}
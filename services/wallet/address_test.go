@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestKeccak256(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		want string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"The quick brown fox jumps over the lazy dog",
+			"4d741b6f1eb29cb2a9b9911c82f56fa8d73b04959d3d9d222895df6c0b28aa15"},
+		// exactly one block, then more than one
+		{strings.Repeat("a", keccakRate), "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+		{strings.Repeat("a", 200), "96ea54061def936c4be90b518992fdc6f12f535068a256229aca54267b4d084d"},
+	} {
+		sum := keccak256([]byte(test.data))
+		if got := hex.EncodeToString(sum[:]); got != test.want {
+			t.Errorf("keccak256(%d bytes) = %s, want %s", len(test.data), got, test.want)
+		}
+	}
+}
+
+func TestBase58(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		want string
+	}{
+		{"00", "11"},
+		{"39", "1z"},
+		{"ff", "5Q"},
+		{"ffff", "LUv"},
+		{"0000000000000000", "11111111111"},
+		{"0102030405060708090a0b", "1An6UebxCZd1436i"},
+	} {
+		data, _ := hex.DecodeString(test.data)
+		if got := encodeBase58(data); got != test.want {
+			t.Errorf("encodeBase58(%s) = %s, want %s", test.data, got, test.want)
+		}
+		back, err := decodeBase58(test.want)
+		if err != nil || !bytes.Equal(back, data) {
+			t.Errorf("decodeBase58(%s) = %x, %v, want %s", test.want, back, err, test.data)
+		}
+	}
+	// a block length no data encodes to, a character outside of the
+	// alphabet, and blocks overflowing their size
+	for _, s := range []string{"1111", "11111111110", "zz", "zzzzzzzzzzz"} {
+		if _, err := decodeBase58(s); err != errAddressFormat {
+			t.Errorf("decodeBase58(%s) = %v, want %v", s, err, errAddressFormat)
+		}
+	}
+}
+
+func TestEncodeAddress(t *testing.T) {
+	spend := "3bcb82eecc13739b463b386fc1ed991386a046b478bf4864673ca0a229c3cec1"
+	view := "72ed2cd0b1ea6f19b84f3b4ea4ac1c4d98b92d36dac5b9d1a8df3b4fb9eb1b7d"
+	paymentID := strings.Repeat("b", 64)
+	for _, test := range []struct {
+		paymentID string
+		want      string
+	}{
+		{"", "TRTLuy1HRt1b8nyYCCjhkrZSM3oG6XkfmMCQ9VxQmWpy7zAxEPnFQquWm1LENnkkpuUYXc2YSWMPsdbNEEK74UHCY6eCBtmgBar"},
+		{paymentID, "TRTLuyzVdq3HTTASy5qnGhHTTASy5qnGhHTTASy5qnGhHTTASy5qnGhHTTASy5qnGhHTTASy5qnGhHTTASy5qnGhHTTASx6daKfb8nyYCCjhkrZSM3oG6XkfmMCQ9VxQmWpy7zAxEPnFQquWm1LENnkkpuUYXc2YSWMPsdbNEEK74UHCY6eCBr5UsGw"},
+	} {
+		address, err := encodeAddress(spend, view, test.paymentID)
+		if err != nil || address != test.want {
+			t.Errorf("encodeAddress(payment id %q) = %s, %v, want %s", test.paymentID, address, err, test.want)
+			continue
+		}
+		addr, err := decodeAddress(address)
+		if err != nil {
+			t.Errorf("decodeAddress(%s): %v", address, err)
+			continue
+		}
+		if addr.SpendPublicKey != spend || addr.ViewPublicKey != view || addr.PaymentID != test.paymentID {
+			t.Errorf("decodeAddress(%s) = %+v", address, addr)
+		}
+		if standard := addr.standard(); !strings.HasPrefix(standard, "TRTL") || len(standard) != 99 {
+			t.Errorf("standard address %s", standard)
+		}
+	}
+	if _, err := encodeAddress(spend, view, "abc"); err == nil {
+		t.Error("encodeAddress took a short payment id")
+	}
+	if _, err := encodeAddress(spend[2:], view, ""); err == nil {
+		t.Error("encodeAddress took a short spend key")
+	}
+}
+
+// typo - address with one character changed, lowering the value of its
+// block so it still decodes
+func typo(address string) string {
+	b := []byte(address)
+	for i := len(b) / 2; ; i++ {
+		if b[i] != '1' {
+			b[i] = '1'
+			return string(b)
+		}
+	}
+}
+
+func TestDecodeAddressErrors(t *testing.T) {
+	address := randomAddress()
+	// a well formed address of another coin
+	data := make([]byte, binary.MaxVarintLen64)
+	data = append(data[:binary.PutUvarint(data, 18)], make([]byte, addressKeysSize)...)
+	sum := keccak256(data)
+	other := encodeBase58(append(data, sum[:addressChecksumSize]...))
+
+	for _, test := range []struct {
+		address string
+		want    error
+	}{
+		{typo(address), errAddressChecksum},
+		{other, errAddressPrefix},
+		{address[:len(address)-11], errAddressFormat},
+		{"TRTL" + strings.Repeat("0", 95), errAddressFormat},
+	} {
+		if _, err := decodeAddress(test.address); err != test.want {
+			t.Errorf("decodeAddress(%s) = %v, want %v", test.address, err, test.want)
+		}
+	}
+}
+
+func TestCheckDestination(t *testing.T) {
+	integrated, _ := encodeAddress(randomHex(32), randomHex(32), randomHex(32))
+	if _, err := checkDestination(integrated, randomHex(32)); err == nil {
+		t.Error("checkDestination took a payment id for an integrated address")
+	}
+	addr, err := checkDestination(integrated, "")
+	if err != nil || !addr.integrated() {
+		t.Errorf("checkDestination(%s) = %+v, %v", integrated, addr, err)
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	paymentID := randomHex(32)
+	integrated, _ := encodeAddress(randomHex(32), randomHex(32), paymentID)
+	response := serve(t, validateAddress, nil, httprouter.Param{Key: "address", Value: integrated})
+	if response.Status != "OK" || response.Data["integrated"] != true || response.Data["paymentID"] != paymentID {
+		t.Errorf("validate %s = %s %v", integrated, response.Status, response.Data)
+	}
+	response = serve(t, validateAddress, nil, httprouter.Param{Key: "address", Value: typo(integrated)})
+	if response.Status != errAddressChecksum.Error() {
+		t.Errorf("validate a typo = %q, want %q", response.Status, errAddressChecksum)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// rpcTimeout - upper bound for a single walletd call made by an http handler
+const rpcTimeout = 10 * time.Second
+
+// WalletBackend - the subset of the walletd api used by the wallet service
+type WalletBackend interface {
+	CreateAddress(ctx context.Context) (string, error)
+	ImportAddress(ctx context.Context, spendSecretKey string, scanHeight int64) (string, error)
+	CreateTrackingAddress(ctx context.Context, spendPublicKey string, scanHeight int64) (string, error)
+	CreateIntegratedAddress(ctx context.Context, address, paymentID string) (string, error)
+	DeleteAddress(ctx context.Context, address string) error
+	GetBalance(ctx context.Context, address string) (*Balance, error)
+	GetStatus(ctx context.Context) (*Status, error)
+	GetTransactions(ctx context.Context, firstBlockIndex, blockCount int64) ([]Block, error)
+	GetBlockHashes(ctx context.Context, firstBlockIndex, blockCount int64) ([]string, error)
+	GetUnconfirmedTransactionHashes(ctx context.Context, addresses []string) ([]string, error)
+	GetTransaction(ctx context.Context, hash string) (*Transaction, error)
+	SendTransaction(ctx context.Context, tx *TransactionRequest) (string, error)
+	CreateDelayedTransaction(ctx context.Context, tx *TransactionRequest) (string, error)
+	GetDelayedTransactionHashes(ctx context.Context) ([]string, error)
+	SendDelayedTransaction(ctx context.Context, hash string) error
+	DeleteDelayedTransaction(ctx context.Context, hash string) error
+	EstimateFusion(ctx context.Context, threshold int64, addresses []string) (*FusionEstimate, error)
+	SendFusionTransaction(ctx context.Context, tx *FusionRequest) (string, error)
+	GetViewKey(ctx context.Context) (string, error)
+	GetSpendKeys(ctx context.Context, address string) (*SpendKeys, error)
+	GetMnemonicSeed(ctx context.Context, address string) (string, error)
+	Save(ctx context.Context) error
+}
+
+// Balance - balance of a single address in atomic units
+type Balance struct {
+	AvailableBalance int64 `json:"availableBalance"`
+	LockedAmount     int64 `json:"lockedAmount"`
+}
+
+// Status - sync status of the container
+type Status struct {
+	BlockCount            int64  `json:"blockCount"`
+	KnownBlockCount       int64  `json:"knownBlockCount"`
+	LocalDaemonBlockCount int64  `json:"localDaemonBlockCount"`
+	LastBlockHash         string `json:"lastBlockHash"`
+	PeerCount             int64  `json:"peerCount"`
+}
+
+// SpendKeys - spend key pair of an address
+type SpendKeys struct {
+	SpendSecretKey string `json:"spendSecretKey"`
+	SpendPublicKey string `json:"spendPublicKey"`
+}
+
+// Block - a block and the container transactions found in it
+type Block struct {
+	BlockHash    string        `json:"blockHash"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// Transaction - a transaction as reported by getTransactions
+type Transaction struct {
+	TransactionHash string     `json:"transactionHash"`
+	BlockIndex      int64      `json:"blockIndex"`
+	Timestamp       int64      `json:"timestamp"`
+	IsBase          bool       `json:"isBase"`
+	UnlockTime      int64      `json:"unlockTime"`
+	Amount          int64      `json:"amount"`
+	Fee             int64      `json:"fee"`
+	Extra           string     `json:"extra"`
+	PaymentID       string     `json:"paymentId"`
+	Transfers       []Transfer `json:"transfers"`
+}
+
+// walletd transfer types
+const (
+	transferTypeUsual = iota
+	transferTypeDonation
+	transferTypeChange
+)
+
+// Transfer - a single transfer inside a transaction
+type Transfer struct {
+	Type    int    `json:"type"`
+	Address string `json:"address"`
+	Amount  int64  `json:"amount"`
+}
+
+// TransactionRequest - parameters for sendTransaction
+type TransactionRequest struct {
+	Addresses     []string      `json:"addresses"`
+	Transfers     []Destination `json:"transfers"`
+	Fee           int64         `json:"fee"`
+	UnlockTime    int64         `json:"unlockTime"`
+	Anonymity     int           `json:"anonymity"`
+	Extra         string        `json:"extra,omitempty"`
+	PaymentID     string        `json:"paymentId,omitempty"`
+	ChangeAddress string        `json:"changeAddress,omitempty"`
+}
+
+// Destination - an outgoing transfer
+type Destination struct {
+	Address string `json:"address"`
+	Amount  int64  `json:"amount"`
+}
+
+// FusionEstimate - outputs of the addresses below the threshold that a
+// fusion transaction can consolidate
+type FusionEstimate struct {
+	FusionReadyCount int64 `json:"fusionReadyCount"`
+	TotalOutputCount int64 `json:"totalOutputCount"`
+}
+
+// FusionRequest - parameters for sendFusionTransaction
+type FusionRequest struct {
+	Threshold          int64    `json:"threshold"`
+	Anonymity          int      `json:"anonymity"`
+	Addresses          []string `json:"addresses"`
+	DestinationAddress string   `json:"destinationAddress"`
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupPrefix     = "shellnet-"
+	backupExt        = ".bak"
+	backupTimeFormat = "20060102T150405Z"
+	backupRetry      = 10 * time.Minute // wait after a failed backup
+	dumpTimeout      = 30 * time.Minute
+	manifestEntry    = "manifest.json"
+	dumpEntry        = "tx_history.dump"
+	maxManifestSize  = 1 << 20
+)
+
+// archives are a gzipped tar sealed in chunks with AES-256-GCM: the magic,
+// a random nonce prefix, then every chunk of plaintext sealed with the
+// nonce prefix, the chunk counter and a flag set on the final chunk, which
+// is always shorter than the others so truncation can be detected
+const (
+	backupMagic     = "SHNBAK01"
+	backupChunkSize = 64 << 10
+	noncePrefixSize = 7
+)
+
+var (
+	backupDir        string // BACKUP_DIR, backups are off when empty
+	backupKey        []byte // BACKUP_KEY, 32 bytes given as hex
+	backupInterval   = 24 * time.Hour
+	backupKeep       = 7 // newest archives kept
+	backupKeepWeekly = 4 // weeks for which the newest archive is also kept
+	pgDump           = "pg_dump"
+	pgRestore        = "pg_restore"
+	containerFiles   = map[string]string{} // container file of each backend
+)
+
+// backupManifest - contents of an archive, stored as its last entry
+type backupManifest struct {
+	Version int
+	Created time.Time
+	Files   []backupFile
+}
+
+// backupFile - an entry of an archive
+type backupFile struct {
+	Name    string
+	Backend string // empty for the database dump
+	Height  int64  // scan checkpoint of the backend in the dump, taken when its container was saved
+	Size    int64
+	SHA256  string
+}
+
+// backupArchive - an archive found in the backup directory
+type backupArchive struct {
+	path    string
+	created time.Time
+}
+
+// backupWorker - makes an archive whenever the newest one is older than
+// backupInterval and applies the retention rules
+func backupWorker() {
+	for ; !shuttingDown(); pause(time.Minute) {
+		archives, err := listBackups()
+		if err != nil {
+			fmt.Println("backup:", err)
+			continue
+		}
+		if len(archives) > 0 && time.Since(archives[0].created) < backupInterval {
+			continue
+		}
+		path, err := runBackup()
+		if err != nil {
+			fmt.Println("backup:", err)
+			pause(backupRetry)
+			continue
+		}
+		fmt.Println("backup: wrote", path)
+		if err = pruneBackups(); err != nil {
+			fmt.Println("backup:", err)
+		}
+	}
+}
+
+// runBackup - writes a new archive and verifies it, returns its path
+func runBackup() (string, error) {
+	created := time.Now().UTC()
+	path := filepath.Join(backupDir, backupPrefix+created.Format(backupTimeFormat)+backupExt)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = writeBackup(f, created)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		_, err = verifyBackup(tmp, "")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// writeBackup - saves every container and writes them with a dump of the
+// database to w. The scanners are paused until the dump is done so the
+// database holds exactly the blocks below the heights in the manifest.
+func writeBackup(w io.Writer, created time.Time) error {
+	for _, service := range serviceList {
+		service.scanMux.Lock()
+		defer service.scanMux.Unlock()
+	}
+	sealed, err := newSealWriter(w, backupKey)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(sealed)
+	tw := tar.NewWriter(gz)
+	manifest := backupManifest{Version: 1, Created: created}
+	for _, service := range serviceList {
+		path, ok := containerFiles[service.name]
+		if !ok {
+			if _, fake := service.backend.(*fakeBackend); fake {
+				continue
+			}
+			return errors.New("no container file for backend " + service.name +
+				", set CONTAINER_FILE_" + strings.ToUpper(service.name))
+		}
+		entry, err := backupContainer(tw, service, path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, *entry)
+	}
+	entry, err := backupDatabase(tw, created)
+	if err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, *entry)
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, _, err = addEntry(tw, manifestEntry, bytes.NewReader(b), int64(len(b)), created); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return sealed.Close()
+}
+
+// backupContainer - saves the container of a backend and archives its file,
+// walletd can't save again until the copy is done
+func backupContainer(tw *tar.Writer, service *TurtleService, path string) (*backupFile, error) {
+	service.saveMux.Lock()
+	defer service.saveMux.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	err := service.backend.Save(ctx)
+	cancel()
+	if err != nil {
+		return nil, errors.New("save " + service.name + ": " + err.Error())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entry := &backupFile{
+		Name:    "containers/" + service.name + ".wallet",
+		Backend: service.name,
+		Height:  service.ScanHeight,
+	}
+	entry.Size, entry.SHA256, err = addEntry(tw, entry.Name, f, info.Size(), info.ModTime())
+	return entry, err
+}
+
+// backupDatabase - archives a pg_dump of tx_history in its custom format
+func backupDatabase(tw *tar.Writer, created time.Time) (*backupFile, error) {
+	tmp := filepath.Join(backupDir, backupPrefix+created.Format(backupTimeFormat)+".dump.tmp")
+	defer os.Remove(tmp)
+	ctx, cancel := context.WithTimeout(context.Background(), dumpTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, pgDump, "--format=custom", "--file="+tmp,
+		"--host=localhost", "--username="+dbUser, "tx_history")
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+dbPwd)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pg_dump: %v: %s", err, bytes.TrimSpace(out))
+	}
+	f, err := os.Open(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entry := &backupFile{Name: dumpEntry}
+	entry.Size, entry.SHA256, err = addEntry(tw, entry.Name, f, info.Size(), created)
+	return entry, err
+}
+
+// addEntry - writes size bytes of r as the file name, returns the size and
+// sha256 written
+func addEntry(tw *tar.Writer, name string, r io.Reader, size int64, modTime time.Time) (int64, string, error) {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: size, ModTime: modTime,
+		Typeflag: tar.TypeReg})
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tw, h), io.LimitReader(r, size))
+	if err != nil {
+		return 0, "", err
+	}
+	if n != size {
+		return 0, "", errors.New(name + " changed while it was archived")
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyBackup - decrypts an archive and checks every entry against its
+// manifest. The entries are extracted to dir unless it is empty.
+func verifyBackup(path, dir string) (*backupManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	opened, err := newOpenReader(f, backupKey)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(opened)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	var manifest *backupManifest
+	found := map[string]backupFile{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == manifestEntry {
+			manifest = &backupManifest{}
+			if err = json.NewDecoder(io.LimitReader(tr, maxManifestSize)).Decode(manifest); err != nil {
+				return nil, errors.New("bad manifest: " + err.Error())
+			}
+			continue
+		}
+		entry, err := readEntry(tr, hdr.Name, dir)
+		if err != nil {
+			return nil, err
+		}
+		found[hdr.Name] = *entry
+	}
+	// reading to the end checks the gzip checksum and the final chunk
+	if _, err = io.Copy(ioutil.Discard, gz); err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, errors.New("archive has no manifest")
+	}
+	for _, want := range manifest.Files {
+		got, ok := found[want.Name]
+		if !ok {
+			return nil, errors.New("archive is missing " + want.Name)
+		}
+		if got.Size != want.Size || got.SHA256 != want.SHA256 {
+			return nil, errors.New(want.Name + " does not match the manifest")
+		}
+		delete(found, want.Name)
+	}
+	for name := range found {
+		return nil, errors.New("archive has unexpected entry " + name)
+	}
+	return manifest, nil
+}
+
+// readEntry - hashes the current entry of tr, writing it below dir if set
+func readEntry(tr *tar.Reader, name, dir string) (*backupFile, error) {
+	h := sha256.New()
+	w := io.Writer(h)
+	if dir != "" {
+		clean := filepath.Clean(filepath.FromSlash(name))
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, errors.New("archive entry outside of the archive: " + name)
+		}
+		dest := filepath.Join(dir, clean)
+		if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, err
+		}
+		defer out.Close()
+		w = io.MultiWriter(h, out)
+	}
+	n, err := io.Copy(w, tr)
+	if err != nil {
+		return nil, err
+	}
+	return &backupFile{Name: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// listBackups - archives in the backup directory, newest first
+func listBackups() ([]backupArchive, error) {
+	files, err := ioutil.ReadDir(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	archives := []backupArchive{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+		created, err := time.Parse(backupTimeFormat,
+			strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupExt))
+		if err != nil {
+			continue
+		}
+		archives = append(archives, backupArchive{path: filepath.Join(backupDir, name), created: created})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].created.After(archives[j].created) })
+	return archives, nil
+}
+
+// pruneBackups - keeps the newest backupKeep archives and the newest archive
+// of each of the last backupKeepWeekly weeks that have one, deletes the rest
+// and whatever an interrupted backup left behind
+func pruneBackups() error {
+	archives, err := listBackups()
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	weeks := map[string]bool{}
+	for i, archive := range archives {
+		if i < backupKeep {
+			keep[archive.path] = true
+		}
+		year, week := archive.created.ISOWeek()
+		key := fmt.Sprintf("%d-%d", year, week)
+		if !weeks[key] && len(weeks) < backupKeepWeekly {
+			weeks[key] = true
+			keep[archive.path] = true
+		}
+	}
+	for _, archive := range archives {
+		if !keep[archive.path] {
+			if err = os.Remove(archive.path); err != nil {
+				return err
+			}
+			fmt.Println("backup: removed", archive.path)
+		}
+	}
+	leftovers, err := filepath.Glob(filepath.Join(backupDir, backupPrefix+"*.tmp"))
+	if err != nil {
+		return err
+	}
+	for _, path := range leftovers {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// sealWriter - encrypts what is written to it in chunks
+type sealWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("backup key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newSealWriter(w io.Writer, key []byte) (*sealWriter, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err = rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(w, backupMagic); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &sealWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, backupChunkSize)}, nil
+}
+
+// chunkNonce - nonce of chunk n
+func chunkNonce(prefix []byte, n uint32, final bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = append(nonce, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], n)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+func (s *sealWriter) seal(final bool) error {
+	if s.counter == ^uint32(0) {
+		return errors.New("archive too large")
+	}
+	out := s.aead.Seal(nil, chunkNonce(s.prefix, s.counter, final), s.buf, []byte(backupMagic))
+	s.counter++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(out)
+	return err
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+		if len(s.buf) == backupChunkSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close - seals the final chunk, which is empty if the data filled the
+// last one
+func (s *sealWriter) Close() error {
+	return s.seal(true)
+}
+
+// openReader - decrypts what a sealWriter wrote
+type openReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte // encrypted chunk being read
+	plain   []byte // decrypted data not read yet
+	final   bool
+}
+
+func newOpenReader(r io.Reader, key []byte) (*openReader, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(backupMagic)+noncePrefixSize)
+	if _, err = io.ReadFull(r, header); err != nil || string(header[:len(backupMagic)]) != backupMagic {
+		return nil, errors.New("not a backup archive")
+	}
+	return &openReader{r: r, aead: aead, prefix: header[len(backupMagic):],
+		chunk: make([]byte, backupChunkSize+aead.Overhead())}, nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.final {
+			if n, _ := o.r.Read(o.chunk[:1]); n > 0 {
+				return 0, errors.New("archive has data after its end")
+			}
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(o.r, o.chunk)
+		switch err {
+		case nil:
+		case io.ErrUnexpectedEOF:
+			o.final = true
+		case io.EOF:
+			return 0, errors.New("archive is truncated")
+		default:
+			return 0, err
+		}
+		o.plain, err = o.aead.Open(o.chunk[:0], chunkNonce(o.prefix, o.counter, o.final),
+			o.chunk[:n], []byte(backupMagic))
+		if err != nil {
+			return 0, errors.New("archive is corrupt or the backup key is wrong")
+		}
+		o.counter++
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxBatchRows      = 500 // rows accepted in a single batch
+	maxBatchTransfers = 15  // transfers packed into one transaction before splitting
+)
+
+// batchRow - a single destination of a batch send
+type batchRow struct {
+	Destination string `json:"destination"`
+	Amount      string `json:"amount"`
+	PaymentID   string `json:"payment_id"`
+}
+
+// batchTx - a transaction planned from one or more batch rows
+type batchTx struct {
+	Rows      []int
+	PaymentID string
+	Amount    float64
+	Fee       float64
+	Hash      string
+	Error     string
+	transfers []Destination
+}
+
+// sendBatch - validates a list of destinations and sends them in as few
+// transactions as walletd accepts. With dry_run set nothing is sent and the
+// planned transactions are returned.
+func sendBatch(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	address := req.FormValue("address")
+	rows := []batchRow{}
+	if err := json.Unmarshal([]byte(req.FormValue("transfers")), &rows); err != nil {
+		encoder.Encode(jsonResponse{Status: "Incorrect Transfers Format"})
+		return
+	}
+	if len(rows) == 0 || len(rows) > maxBatchRows {
+		encoder.Encode(jsonResponse{Status: "A batch must have between 1 and 500 rows"})
+		return
+	}
+
+	rowErrors := make([]string, len(rows))
+	valid := true
+	for i := range rows {
+		rows[i].Destination = strings.TrimSpace(rows[i].Destination)
+		rows[i].Amount = strings.TrimSpace(rows[i].Amount)
+		rows[i].PaymentID = strings.TrimSpace(rows[i].PaymentID)
+		if rowErrors[i] = validateDestination(&rows[i]); rowErrors[i] != "" {
+			valid = false
+		}
+	}
+	plan := planBatch(rows)
+	data := map[string]interface{}{
+		"rows":         rows,
+		"errors":       rowErrors,
+		"transactions": plan,
+		"total":        batchTotal(plan),
+		"fee":          float64(len(plan)*transactionFee) / divisor,
+	}
+	if !valid {
+		encoder.Encode(jsonResponse{Status: "Invalid rows in batch", Data: data})
+		return
+	}
+	// held withdrawals are single sends, a batch can't be split under the
+	// threshold to skip the approval
+	if needsApproval(batchAmount(plan)) {
+		encoder.Encode(jsonResponse{Status: "Batch total needs approval, send the large transfers on their own",
+			Data: data})
+		return
+	}
+	if req.FormValue("dry_run") != "" {
+		encoder.Encode(jsonResponse{Status: "OK", Data: data})
+		return
+	}
+
+	requestKey := req.FormValue("request_key")
+	if matched, _ := regexp.MatchString(requestKeyFormat, requestKey); !matched {
+		encoder.Encode(jsonResponse{Status: "Missing or Incorrect Request Key", Data: data})
+		return
+	}
+	backend, err := senderFor(address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error(), Data: data})
+		return
+	}
+	// the batch is claimed like a single send, dest fingerprints its rows
+	// so the key can't be reused for a different batch
+	request := &sendRequest{key: requestKey, address: address, dest: batchFingerprint(rows),
+		amount: batchAmount(plan)}
+	_, fresh, err := beginSend(request)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error(), Data: data})
+		return
+	}
+	if !fresh {
+		sent, err := loadBatch(requestKey)
+		if err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error(), Data: data})
+			return
+		}
+		data["transactions"] = sent
+		data["fee"] = batchFees(sent)
+		data["duplicate"] = true
+		encoder.Encode(jsonResponse{Status: "OK", Data: data})
+		return
+	}
+	// the spending policy takes the whole batch as one send
+	transfers := []Destination{}
+	for _, tx := range plan {
+		transfers = append(transfers, tx.transfers...)
+	}
+	if err = reserveSpend(address, request.key, transfers); err != nil {
+		finishSend(request, "", err)
+		encoder.Encode(jsonResponse{Status: err.Error(), Data: data})
+		return
+	}
+	// not bound to the request, the outcome has to be recorded even if the
+	// caller goes away
+	sent := []*batchTx{}
+	for _, tx := range plan {
+		sent = append(sent, sendBatchTx(context.Background(), backend, address, tx)...)
+	}
+	var spent int64
+	for _, tx := range sent {
+		if tx.Hash != "" || tx.Error == errSendUnknown.Error() {
+			for _, d := range tx.transfers {
+				spent += d.Amount
+			}
+		}
+	}
+	settleSpend(request.key, spent)
+	finishBatch(request, sent)
+	data["transactions"] = sent
+	data["fee"] = batchFees(sent)
+	encoder.Encode(jsonResponse{Status: "OK", Data: data})
+}
+
+// batchFingerprint - identifies the rows of a batch in its send request
+func batchFingerprint(rows []batchRow) string {
+	buf, _ := json.Marshal(rows)
+	sum := sha256.Sum256(buf)
+	return "batch:" + hex.EncodeToString(sum[:])
+}
+
+// finishBatch - stores the transactions sent for a claimed batch. The
+// request keeps the first hash, or the first error if nothing was sent.
+func finishBatch(r *sendRequest, sent []*batchTx) {
+	var hash string
+	var sendErr error
+	for _, tx := range sent {
+		if tx.Hash != "" {
+			hash = tx.Hash
+			break
+		}
+		if sendErr == nil {
+			sendErr = errors.New(tx.Error)
+		}
+	}
+	if hash != "" {
+		sendErr = nil
+	}
+	buf, _ := json.Marshal(sent)
+	if _, err := walletDB.Exec("UPDATE send_requests SET batch = $2 WHERE request_key = $1;",
+		strings.ToLower(r.key), string(buf)); err != nil {
+		fmt.Println("send request", r.key, err)
+	}
+	finishSend(r, hash, sendErr)
+}
+
+// loadBatch - the transactions stored for a batch sent before
+func loadBatch(key string) ([]*batchTx, error) {
+	var buf sql.NullString
+	err := walletDB.QueryRow("SELECT batch FROM send_requests WHERE request_key = $1;",
+		strings.ToLower(key)).Scan(&buf)
+	if err != nil {
+		return nil, err
+	}
+	sent := []*batchTx{}
+	if !buf.Valid {
+		return sent, nil
+	}
+	err = json.Unmarshal([]byte(buf.String), &sent)
+	return sent, err
+}
+
+// batchFees - network fees paid by the sent transactions in coin units
+func batchFees(sent []*batchTx) float64 {
+	fees := 0
+	for _, tx := range sent {
+		if tx.Hash != "" {
+			fees += transactionFee
+		}
+	}
+	return float64(fees) / divisor
+}
+
+// validateDestination - returns a message describing what is wrong with row
+func validateDestination(row *batchRow) string {
+	if _, err := checkDestination(row.Destination, row.PaymentID); err != nil {
+		return err.Error()
+	}
+	if matched, _ := regexp.MatchString(amountFormat, row.Amount); !matched {
+		return "Incorrect Amount Format"
+	}
+	amount, _ := parseAmount(row.Amount)
+	if amount <= 0 {
+		return "Incorrect Amount Format"
+	}
+	if needsApproval(amount) {
+		return "Amount needs approval, send it on its own"
+	}
+	if matched, _ := regexp.MatchString(paymentIDFormat, row.PaymentID); !matched && row.PaymentID != "" {
+		return "Incorrect Payment ID Format"
+	}
+	return ""
+}
+
+// planBatch - groups rows by payment id, a transaction carries only one,
+// and packs each group into transactions of at most maxBatchTransfers
+func planBatch(rows []batchRow) []*batchTx {
+	plan := []*batchTx{}
+	open := map[string]*batchTx{}
+	for i, row := range rows {
+		key := batchGroup(row)
+		tx, ok := open[key]
+		if !ok || len(tx.Rows) == maxBatchTransfers {
+			tx = &batchTx{PaymentID: strings.ToLower(row.PaymentID), Fee: transactionFee / divisor}
+			open[key] = tx
+			plan = append(plan, tx)
+		}
+		amount, _ := parseAmount(row.Amount)
+		tx.Rows = append(tx.Rows, i)
+		tx.Amount += float64(amount) / divisor
+		tx.transfers = append(tx.transfers, Destination{Address: row.Destination, Amount: amount})
+	}
+	return plan
+}
+
+// batchGroup - the payment id a row is grouped by. Integrated destinations
+// carry their own and only share a transaction with destinations embedding
+// the same one, never with plain destinations.
+func batchGroup(row batchRow) string {
+	if addr, err := decodeAddress(row.Destination); err == nil && addr.integrated() {
+		return "integrated:" + addr.PaymentID
+	}
+	return strings.ToLower(row.PaymentID)
+}
+
+// sendBatchTx - sends a planned transaction, halving it while walletd
+// reports it as too big
+func sendBatchTx(ctx context.Context, backend WalletBackend, address string, tx *batchTx) []*batchTx {
+	rctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	hash, err := backend.SendTransaction(rctx, &TransactionRequest{
+		Addresses: []string{address},
+		Transfers: tx.transfers,
+		Fee:       transactionFee,
+		Anonymity: 3, // mixin
+		PaymentID: tx.PaymentID,
+	})
+	if err != nil && rctx.Err() != nil {
+		err = errSendUnknown
+	}
+	cancel()
+	if err != nil && isTooBig(err) && len(tx.transfers) > 1 {
+		half := len(tx.transfers) / 2
+		first := &batchTx{PaymentID: tx.PaymentID, Fee: tx.Fee, Rows: tx.Rows[:half], transfers: tx.transfers[:half]}
+		second := &batchTx{PaymentID: tx.PaymentID, Fee: tx.Fee, Rows: tx.Rows[half:], transfers: tx.transfers[half:]}
+		for _, t := range []*batchTx{first, second} {
+			for _, d := range t.transfers {
+				t.Amount += float64(d.Amount) / divisor
+			}
+		}
+		return append(sendBatchTx(ctx, backend, address, first), sendBatchTx(ctx, backend, address, second)...)
+	}
+	if err != nil {
+		tx.Error = err.Error()
+	}
+	tx.Hash = hash
+	return []*batchTx{tx}
+}
+
+// isTooBig - checks if walletd rejected a transaction for its size
+func isTooBig(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "too big")
+}
+
+// batchAmount - sum of all planned transfers in atomic units
+func batchAmount(plan []*batchTx) int64 {
+	var amount int64
+	for _, tx := range plan {
+		for _, d := range tx.transfers {
+			amount += d.Amount
+		}
+	}
+	return amount
+}
+
+// batchTotal - sum of all planned transfers in coin units
+func batchTotal(plan []*batchTx) float64 {
+	var total float64
+	for _, tx := range plan {
+		total += tx.Amount
+	}
+	return total
+}
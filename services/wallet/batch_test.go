@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// randomAddress - a valid address outside of the containers
+func randomAddress() string {
+	address, _ := encodeAddress(randomHex(32), randomHex(32), "")
+	return address
+}
+
+func TestPlanBatch(t *testing.T) {
+	rows := []batchRow{}
+	for i := 0; i < maxBatchTransfers+1; i++ {
+		rows = append(rows, batchRow{Destination: randomAddress(), Amount: "1.25"})
+	}
+	paymentID := randomHex(32)
+	rows = append(rows, batchRow{Destination: randomAddress(), Amount: "2", PaymentID: paymentID})
+	rows = append(rows, batchRow{Destination: randomAddress(), Amount: "3", PaymentID: paymentID})
+
+	plan := planBatch(rows)
+	if len(plan) != 3 {
+		t.Fatalf("planned %d transactions, want 3", len(plan))
+	}
+	for i, want := range []struct {
+		rows      int
+		paymentID string
+		amount    float64
+	}{
+		{maxBatchTransfers, "", maxBatchTransfers * 1.25},
+		{1, "", 1.25},
+		{2, paymentID, 5},
+	} {
+		tx := plan[i]
+		if len(tx.Rows) != want.rows || tx.PaymentID != want.paymentID || tx.Amount != want.amount {
+			t.Errorf("transaction %d = %d rows, payment id %q, amount %v, want %d, %q, %v",
+				i, len(tx.Rows), tx.PaymentID, tx.Amount, want.rows, want.paymentID, want.amount)
+		}
+		if tx.Fee != transactionFee/divisor {
+			t.Errorf("transaction %d fee = %v", i, tx.Fee)
+		}
+	}
+	if got, want := batchAmount(plan), int64((maxBatchTransfers+1)*125+500); got != want {
+		t.Errorf("batchAmount = %d, want %d", got, want)
+	}
+	if got, want := batchTotal(plan), float64(maxBatchTransfers+1)*1.25+5; got != want {
+		t.Errorf("batchTotal = %v, want %v", got, want)
+	}
+}
+
+func TestPlanBatchIntegrated(t *testing.T) {
+	first, second := randomHex(32), randomHex(32)
+	integrated := func(paymentID string) string {
+		address, _ := encodeAddress(randomHex(32), randomHex(32), paymentID)
+		return address
+	}
+	rows := []batchRow{
+		{Destination: randomAddress(), Amount: "1"},
+		{Destination: integrated(first), Amount: "2"},
+		{Destination: randomAddress(), Amount: "3"},
+		{Destination: integrated(second), Amount: "4"},
+		{Destination: integrated(first), Amount: "5"},
+		{Destination: randomAddress(), Amount: "6", PaymentID: first},
+	}
+
+	plan := planBatch(rows)
+	want := []struct {
+		rows      []int
+		paymentID string
+	}{
+		{[]int{0, 2}, ""},
+		{[]int{1, 4}, ""},
+		{[]int{3}, ""},
+		{[]int{5}, first},
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("planned %d transactions, want %d", len(plan), len(want))
+	}
+	for i, w := range want {
+		if fmt.Sprint(plan[i].Rows) != fmt.Sprint(w.rows) || plan[i].PaymentID != w.paymentID {
+			t.Errorf("transaction %d = rows %v, payment id %q, want %v, %q",
+				i, plan[i].Rows, plan[i].PaymentID, w.rows, w.paymentID)
+		}
+	}
+}
+
+func TestValidateDestination(t *testing.T) {
+	integrated, _ := encodeAddress(randomHex(32), randomHex(32), randomHex(32))
+	for _, test := range []struct {
+		row  batchRow
+		want string
+	}{
+		{batchRow{Destination: randomAddress(), Amount: "1.5"}, ""},
+		{batchRow{Destination: randomAddress(), Amount: "1.5", PaymentID: randomHex(32)}, ""},
+		{batchRow{Destination: integrated, Amount: "1"}, ""},
+		{batchRow{Destination: integrated, Amount: "1", PaymentID: randomHex(32)},
+			"Integrated addresses carry their own payment ID, leave it empty"},
+		{batchRow{Destination: "TRTL", Amount: "1"}, errAddressFormat.Error()},
+		{batchRow{Destination: randomAddress(), Amount: "1.234"}, "Incorrect Amount Format"},
+		{batchRow{Destination: randomAddress(), Amount: "0"}, "Incorrect Amount Format"},
+		{batchRow{Destination: randomAddress(), Amount: "1", PaymentID: "abc"}, "Incorrect Payment ID Format"},
+	} {
+		if got := validateDestination(&test.row); got != test.want {
+			t.Errorf("validateDestination(%+v) = %q, want %q", test.row, got, test.want)
+		}
+	}
+}
+
+// batchResult - the transactions and their hashes in a sendBatch response
+func batchResult(t *testing.T, response jsonResponse) []string {
+	buf, _ := json.Marshal(response.Data["transactions"])
+	sent := []*batchTx{}
+	if err := json.Unmarshal(buf, &sent); err != nil {
+		t.Fatalf("transactions: %v", err)
+	}
+	hashes := []string{}
+	for _, tx := range sent {
+		hashes = append(hashes, tx.Hash)
+	}
+	return hashes
+}
+
+func TestSendBatch(t *testing.T) {
+	needDB(t)
+	address := createAddress(t)
+	defer dropAddress(t, address)
+
+	// more transfers than the fake takes in one transaction
+	rows := []batchRow{}
+	for i := 0; i < fakeMaxTransfers+2; i++ {
+		rows = append(rows, batchRow{Destination: randomAddress(), Amount: strconv.Itoa(i + 1)})
+	}
+	transfers, _ := json.Marshal(rows)
+	form := url.Values{"address": {address}, "transfers": {string(transfers)}, "dry_run": {"1"}}
+
+	response := serve(t, sendBatch, form)
+	if response.Status != "OK" {
+		t.Fatalf("dry run: %s", response.Status)
+	}
+	if hashes := batchResult(t, response); len(hashes) != 1 || hashes[0] != "" {
+		t.Errorf("dry run planned %v, want one unsent transaction", hashes)
+	}
+	if got := availableBalance(t, address); got != fakeFaucet/divisor {
+		t.Errorf("balance after dry run = %v, want %v", got, fakeFaucet/divisor)
+	}
+
+	form.Del("dry_run")
+	if response = serve(t, sendBatch, form); response.Status != "Missing or Incorrect Request Key" {
+		t.Errorf("batch without request key = %q", response.Status)
+	}
+	form.Set("request_key", randomHex(16))
+	response = serve(t, sendBatch, form)
+	if response.Status != "OK" {
+		t.Fatalf("send: %s", response.Status)
+	}
+	hashes := batchResult(t, response)
+	if len(hashes) != 2 || hashes[0] == "" || hashes[1] == "" {
+		t.Fatalf("sent %v, want the transaction split in two", hashes)
+	}
+	// 1 + 2 + ... + 12 and a fee for each half
+	want := float64(fakeFaucet-78*100-2*transactionFee) / divisor
+	if got := availableBalance(t, address); got != want {
+		t.Errorf("balance after batch = %v, want %v", got, want)
+	}
+
+	response = serve(t, sendBatch, form)
+	if response.Status != "OK" || response.Data["duplicate"] != true {
+		t.Fatalf("resend = %s %v, want a duplicate", response.Status, response.Data["duplicate"])
+	}
+	if again := batchResult(t, response); len(again) != 2 || again[0] != hashes[0] || again[1] != hashes[1] {
+		t.Errorf("resend returned %v, want %v", again, hashes)
+	}
+	if got := availableBalance(t, address); got != want {
+		t.Errorf("balance after resend = %v, want %v", got, want)
+	}
+
+	rows[3].Amount = "1.234"
+	transfers, _ = json.Marshal(rows)
+	form.Set("transfers", string(transfers))
+	form.Set("request_key", randomHex(16))
+	if response = serve(t, sendBatch, form); response.Status != "Invalid rows in batch" {
+		t.Errorf("batch with an invalid row = %q", response.Status)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// legacyDataFile - where the scan height was kept before it moved into the
+// database, read once to seed the checkpoint
+const legacyDataFile = "./data/ha.data"
+
+// loadCheckpoint - the height the scanner of backend continues from. The
+// checkpoint is created on first start, for the default backend from the
+// legacy data file if there is one.
+func loadCheckpoint(backend string) (int64, error) {
+	var seed int64
+	if backend == defaultBackend {
+		seed = legacyScanHeight()
+	}
+	_, err := walletDB.Exec(`INSERT INTO scan_checkpoint (backend, height) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING;`, backend, seed)
+	if err != nil {
+		return 0, err
+	}
+	var height int64
+	err = walletDB.QueryRow("SELECT height FROM scan_checkpoint WHERE backend = $1;", backend).Scan(&height)
+	return height, err
+}
+
+// legacyScanHeight - scan height stored in the legacy data file, 0 when it
+// is missing or unreadable
+func legacyScanHeight() int64 {
+	b, err := ioutil.ReadFile(legacyDataFile)
+	if err != nil {
+		return 0
+	}
+	data := struct {
+		ScanHeight int64 `json:"scanHeight"`
+	}{}
+	if err = json.Unmarshal(b, &data); err != nil {
+		fmt.Println("checkpoint:", legacyDataFile, err)
+		return 0
+	}
+	fmt.Println("checkpoint: seeded from", legacyDataFile, "at height", data.ScanHeight)
+	return data.ScanHeight
+}
+
+// saveCheckpoint - moves the checkpoint of backend to height, hash is the
+// hash of the block below it. Run in the sql transaction that recorded those
+// blocks.
+func saveCheckpoint(tx *sql.Tx, backend string, height int64, hash string) error {
+	_, err := tx.Exec(`UPDATE scan_checkpoint SET height = $2, block_hash = $3, updated = now()
+			WHERE backend = $1;`, backend, height, hash)
+	return err
+}
+
+// rewindCheckpoint - moves the checkpoint of backend back to height if it
+// is past it, taking the hash of the block below from block_hashes
+func rewindCheckpoint(tx *sql.Tx, backend string, height int64) error {
+	_, err := tx.Exec(`UPDATE scan_checkpoint SET height = $2,
+				block_hash = COALESCE((SELECT hash FROM block_hashes
+					WHERE backend = $1 AND height = $2 - 1), ''),
+				updated = now()
+			WHERE backend = $1 AND height > $2;`, backend, height)
+	return err
+}
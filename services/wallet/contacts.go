@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxContacts       = 200 // per address
+	maxContactNameLen = 64
+	maxContactNoteLen = 256
+)
+
+// contactName - selects the name of the contact matching the dest of the
+// row aliased t, empty when there is none
+const contactName = `COALESCE((SELECT c.name FROM contacts c WHERE c.addr_id = t.addr_id
+		AND c.address = trim(t.dest) ORDER BY c.id LIMIT 1), '')`
+
+type contact struct {
+	ID        int
+	Name      string
+	Address   string
+	PaymentID string
+	Notes     string
+}
+
+// getContacts - lists the address book of an address ordered by name
+func getContacts(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	rows, err := walletDB.Query(`SELECT id, name, address, paymentID, notes FROM contacts
+			WHERE addr_id = (SELECT id FROM addresses WHERE address = $1) ORDER BY lower(name);`,
+		p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	defer rows.Close()
+	contacts := make([]contact, 0)
+	for rows.Next() {
+		c := contact{}
+		if err = rows.Scan(&c.ID, &c.Name, &c.Address, &c.PaymentID, &c.Notes); err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		contacts = append(contacts, c)
+	}
+	if err = rows.Err(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"contacts": contacts}})
+}
+
+// saveContact - adds a contact to the address book of address, or updates
+// the one with the given id
+func saveContact(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	address := req.FormValue("address")
+	c := contact{
+		Name:      strings.TrimSpace(req.FormValue("name")),
+		Address:   strings.TrimSpace(req.FormValue("contact_address")),
+		PaymentID: strings.ToLower(strings.TrimSpace(req.FormValue("payment_id"))),
+		Notes:     strings.TrimSpace(req.FormValue("notes")),
+	}
+	if c.Name == "" || len(c.Name) > maxContactNameLen {
+		encoder.Encode(jsonResponse{Status: "Name must be between 1 and 64 characters"})
+		return
+	}
+	if matched, _ := regexp.MatchString(paymentIDFormat, c.PaymentID); !matched && c.PaymentID != "" {
+		encoder.Encode(jsonResponse{Status: "Incorrect Payment ID Format"})
+		return
+	}
+	if _, err := checkDestination(c.Address, c.PaymentID); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if len(c.Notes) > maxContactNoteLen {
+		encoder.Encode(jsonResponse{Status: "Notes are too long"})
+		return
+	}
+
+	if id := req.FormValue("id"); id != "" {
+		var err error
+		if c.ID, err = strconv.Atoi(id); err != nil {
+			encoder.Encode(jsonResponse{Status: "Contact not found"})
+			return
+		}
+		result, err := walletDB.Exec(`UPDATE contacts SET name = $3, address = $4, paymentID = $5, notes = $6
+				WHERE id = $2 AND addr_id = (SELECT id FROM addresses WHERE address = $1);`,
+			address, c.ID, c.Name, c.Address, c.PaymentID, c.Notes)
+		if err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			encoder.Encode(jsonResponse{Status: "Contact not found"})
+			return
+		}
+		encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"contact": c}})
+		return
+	}
+
+	var count int
+	err := walletDB.QueryRow(`SELECT count(*) FROM contacts
+			WHERE addr_id = (SELECT id FROM addresses WHERE address = $1);`, address).Scan(&count)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if count >= maxContacts {
+		encoder.Encode(jsonResponse{Status: "Address book is full"})
+		return
+	}
+	err = walletDB.QueryRow(`INSERT INTO contacts (addr_id, name, address, paymentID, notes)
+			SELECT id, $2, $3, $4, $5 FROM addresses WHERE address = $1 RETURNING id;`,
+		address, c.Name, c.Address, c.PaymentID, c.Notes).Scan(&c.ID)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"contact": c}})
+}
+
+// deleteContact - removes a contact from the address book of address
+func deleteContact(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	_, err := walletDB.Exec(`DELETE FROM contacts WHERE id = $2
+			AND addr_id = (SELECT id FROM addresses WHERE address = $1);`,
+		req.FormValue("address"), req.FormValue("id"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK"})
+}
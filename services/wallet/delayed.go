@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	delayedTTL      = "10 minutes" // unconfirmed delayed transactions are deleted after this long
+	delayedInterval = time.Minute
+)
+
+// delayed transaction states
+const (
+	delayedCreated = "created"
+	delayedSending = "sending"
+	delayedSent    = "sent"
+	delayedFailed  = "failed"
+	delayedDeleted = "deleted"
+	delayedHeld    = "held" // replaced by a withdrawal waiting for approval
+)
+
+var errDelayedGone = errors.New("Transaction expired or was cancelled, please create it again")
+
+// delayedReview - what a delayed transaction will do once sent, in coin units
+type delayedReview struct {
+	Hash        string
+	Destination string
+	PaymentID   string
+	Amount      float64
+	Fee         float64
+	Inputs      float64
+	Change      float64
+	Expires     string
+}
+
+// prepareTransaction - builds a transaction in walletd without relaying it
+// and returns it for review. Preparing again with the same request key
+// returns the transaction built the first time.
+func prepareTransaction(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	request, err := parseSendRequest(req)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if review, err := loadReview(request); err != sql.ErrNoRows {
+		encodeReview(encoder, review, err)
+		return
+	}
+	backend, err := senderFor(request.address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	// checked again when confirmed, this spares reviewing a refused send
+	transfers := []Destination{{Address: request.dest, Amount: request.amount}}
+	if err = reserveSpend(request.address, "", transfers); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	hash, err := backend.CreateDelayedTransaction(ctx, &TransactionRequest{
+		Addresses: []string{request.address},
+		Transfers: transfers,
+		Fee:       transactionFee,
+		Anonymity: 3, // mixin
+		PaymentID: request.paymentID,
+	})
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	tx, err := backend.GetTransaction(ctx, hash)
+	if err != nil {
+		backend.DeleteDelayedTransaction(ctx, hash)
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+
+	var inputs, change int64
+	for _, t := range tx.Transfers {
+		if t.Amount < 0 {
+			inputs -= t.Amount
+		} else if t.Type == transferTypeChange {
+			change += t.Amount
+		}
+	}
+	result, err := walletDB.Exec(`INSERT INTO delayed_transactions (hash, addr_id, request_key, dest,
+				amount, paymentID, fee, inputs, change)
+			VALUES ($1, (SELECT id FROM addresses WHERE address = $2), $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (request_key) DO NOTHING;`,
+		hash, request.address, strings.ToLower(request.key), request.dest, float64(request.amount)/divisor,
+		request.paymentID, float64(tx.Fee)/divisor, float64(inputs)/divisor, float64(change)/divisor)
+	if err != nil {
+		backend.DeleteDelayedTransaction(ctx, hash)
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// lost a race with a prepare using the same key
+		backend.DeleteDelayedTransaction(ctx, hash)
+	}
+	review, err := loadReview(request)
+	encodeReview(encoder, review, err)
+}
+
+// loadReview - the delayed transaction prepared for request, sql.ErrNoRows
+// if there is none
+func loadReview(request *sendRequest) (*delayedReview, error) {
+	var address, status string
+	var amount float64
+	review := &delayedReview{}
+	err := walletDB.QueryRow(`SELECT a.address, d.hash, d.dest, d.paymentID, d.amount, d.fee, d.inputs,
+				d.change, d.status, to_char(d.created + $2::interval, 'YYYY-MM-DD HH24:MI:SS')
+			FROM delayed_transactions d JOIN addresses a ON a.id = d.addr_id
+			WHERE d.request_key = $1;`, strings.ToLower(request.key), delayedTTL).Scan(
+		&address, &review.Hash, &review.Destination, &review.PaymentID, &amount, &review.Fee,
+		&review.Inputs, &review.Change, &status, &review.Expires)
+	if err != nil {
+		return nil, err
+	}
+	review.Amount = amount
+	if strings.TrimSpace(address) != request.address || review.Destination != request.dest ||
+		review.PaymentID != request.paymentID || int64(math.Round(amount*divisor)) != request.amount {
+		return nil, errKeyReused
+	}
+	switch status {
+	case delayedCreated:
+	case delayedSent, delayedSending:
+		return nil, errors.New("This transaction was already sent")
+	case delayedHeld:
+		return nil, errWithdrawalHeld
+	default:
+		return nil, errDelayedGone
+	}
+	review.Hash = strings.TrimSpace(review.Hash)
+	return review, nil
+}
+
+// encodeReview - writes a review or the error that prevented it
+func encodeReview(encoder *json.Encoder, review *delayedReview, err error) {
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"review": review}})
+}
+
+// confirmTransaction - relays a reviewed delayed transaction
+func confirmTransaction(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	address := req.FormValue("address")
+	hash := req.FormValue("hash")
+	backend, err := senderFor(address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	request := &sendRequest{address: address}
+	var amount float64
+	err = walletDB.QueryRow(`UPDATE delayed_transactions SET status = $3
+			WHERE hash = $1 AND addr_id = (SELECT id FROM addresses WHERE address = $2)
+			AND status = 'created' AND created > now() - $4::interval
+			RETURNING request_key, dest, amount, paymentID;`,
+		hash, address, delayedSending, delayedTTL).Scan(
+		&request.key, &request.dest, &amount, &request.paymentID)
+	if err == sql.ErrNoRows {
+		var status string
+		walletDB.QueryRow(`SELECT status FROM delayed_transactions WHERE hash = $1
+				AND addr_id = (SELECT id FROM addresses WHERE address = $2);`, hash, address).Scan(&status)
+		if status == delayedSent {
+			encoder.Encode(jsonResponse{Status: "OK",
+				Data: map[string]interface{}{"transactionHash": hash, "duplicate": true}})
+			return
+		}
+		if status == delayedHeld {
+			encoder.Encode(jsonResponse{Status: errWithdrawalHeld.Error()})
+			return
+		}
+		if status == delayedSending {
+			// being sent, or walletd timed out and may still relay it
+			encoder.Encode(jsonResponse{Status: errSendUnknown.Error()})
+			return
+		}
+		encoder.Encode(jsonResponse{Status: errDelayedGone.Error()})
+		return
+	}
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	request.amount = int64(math.Round(amount * divisor))
+
+	// the send goes through the request key like a direct send, so a key
+	// used for a direct send can't be relayed twice
+	sentHash, fresh, err := beginSend(request)
+	if err != nil || !fresh {
+		deleteDelayed(backend, hash)
+		if err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		encoder.Encode(jsonResponse{Status: "OK",
+			Data: map[string]interface{}{"transactionHash": sentHash, "duplicate": true}})
+		return
+	}
+	err = reserveSpend(address, request.key, []Destination{{Address: request.dest, Amount: request.amount}})
+	if err != nil {
+		finishSend(request, "", err)
+		deleteDelayed(backend, hash)
+		walletDB.Exec("UPDATE delayed_transactions SET status = $2 WHERE hash = $1;", hash, delayedFailed)
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if needsApproval(request.amount) {
+		// walletd drops delayed transactions long before an admin gets to
+		// them, the approved withdrawal is built again
+		deleteDelayed(backend, hash)
+		id, err := holdWithdrawal(request)
+		if err != nil {
+			settleSpend(request.key, 0)
+			finishSend(request, "", err)
+			walletDB.Exec("UPDATE delayed_transactions SET status = $2 WHERE hash = $1;", hash, delayedFailed)
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		walletDB.Exec("UPDATE delayed_transactions SET status = $2 WHERE hash = $1;", hash, delayedHeld)
+		encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"held": id}})
+		return
+	}
+
+	// not bound to the request, the outcome has to be recorded even if
+	// the caller goes away
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	err = backend.SendDelayedTransaction(ctx, hash)
+	switch {
+	case err == nil:
+		finishSend(request, hash, nil)
+		walletDB.Exec("UPDATE delayed_transactions SET status = $2 WHERE hash = $1;", hash, delayedSent)
+	case ctx.Err() != nil:
+		// walletd may still relay it, so it stays marked as sending
+		err = errSendUnknown
+		finishSend(request, "", err)
+	default:
+		finishSend(request, "", err)
+		settleSpend(request.key, 0)
+		deleteDelayed(backend, hash)
+		walletDB.Exec("UPDATE delayed_transactions SET status = $2 WHERE hash = $1;", hash, delayedFailed)
+	}
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK",
+		Data: map[string]interface{}{"transactionHash": hash}})
+}
+
+// cancelTransaction - deletes a delayed transaction that was not sent
+func cancelTransaction(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	hash := req.FormValue("hash")
+	address := req.FormValue("address")
+	backend, err := backendFor(address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	result, err := walletDB.Exec(`UPDATE delayed_transactions SET status = $3
+			WHERE hash = $1 AND addr_id = (SELECT id FROM addresses WHERE address = $2)
+			AND status = 'created';`, hash, address, delayedDeleted)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		encoder.Encode(jsonResponse{Status: "Transaction not found"})
+		return
+	}
+	deleteDelayed(backend, hash)
+	encoder.Encode(jsonResponse{Status: "OK"})
+}
+
+// deleteDelayed - removes a delayed transaction from walletd
+func deleteDelayed(backend WalletBackend, hash string) {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	if err := backend.DeleteDelayedTransaction(ctx, hash); err != nil {
+		fmt.Println("delayed transaction", hash, err)
+	}
+}
+
+// delayedCollector - deletes delayed transactions of the backend that were
+// not confirmed in time, and ones walletd holds that were never stored (seen
+// twice in a row so a transaction being prepared isn't taken for one)
+func (service *TurtleService) delayedCollector() {
+	orphans := map[string]bool{}
+	for ; !shuttingDown(); pause(delayedInterval) {
+		rows, err := walletDB.Query(`UPDATE delayed_transactions SET status = $1
+				WHERE status = 'created' AND created < now() - $2::interval
+				AND addr_id IN (SELECT id FROM addresses WHERE backend = $3) RETURNING hash;`,
+			delayedDeleted, delayedTTL, service.name)
+		if err != nil {
+			fmt.Println("delayed transactions:", err)
+			continue
+		}
+		expired := []string{}
+		for rows.Next() {
+			var hash string
+			if err = rows.Scan(&hash); err == nil {
+				expired = append(expired, strings.TrimSpace(hash))
+			}
+		}
+		rows.Close()
+		for _, hash := range expired {
+			deleteDelayed(service.backend, hash)
+		}
+		walletDB.Exec(`DELETE FROM delayed_transactions WHERE status != 'created'
+				AND created < now() - $1::interval;`, requestKeyTTL)
+
+		ctx, cancel := service.timeout()
+		hashes, err := service.backend.GetDelayedTransactionHashes(ctx)
+		cancel()
+		if err != nil {
+			fmt.Println("delayed transactions:", err)
+			continue
+		}
+		seen := map[string]bool{}
+		for _, hash := range hashes {
+			var status string
+			err = walletDB.QueryRow("SELECT status FROM delayed_transactions WHERE hash = $1;", hash).Scan(&status)
+			if err == nil && (status == delayedCreated || status == delayedSending) {
+				continue
+			}
+			if orphans[hash] {
+				deleteDelayed(service.backend, hash)
+				continue
+			}
+			seen[hash] = true
+		}
+		orphans = seen
+	}
+}
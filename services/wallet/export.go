@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const exportFlushRows = 100 // rows written between flushes
+
+// transaction directions in exports
+const (
+	directionIn     = "in"
+	directionOut    = "out"
+	directionFusion = "fusion"
+)
+
+// exportRow - a row of the transactions table as exported
+type exportRow struct {
+	ID          int64  `json:"id"`
+	Date        string `json:"date"`
+	Hash        string `json:"hash"`
+	Direction   string `json:"direction"`
+	Destination string `json:"destination"`
+	PaymentID   string `json:"paymentId"`
+	Amount      string `json:"amount"`
+	Fee         string `json:"fee"`
+	BlockHeight int64  `json:"blockHeight"`
+	time        time.Time
+	amount      float64 // coin units
+	fee         float64
+}
+
+// exporter - writes a statement in one format, rows are written as they
+// are read from the database
+type exporter interface {
+	begin(address string, from, to time.Time) error
+	row(r *exportRow) error
+	end() error
+}
+
+// exportFormats - content type and constructor of every supported format
+var exportFormats = map[string]struct {
+	contentType string
+	create      func(w io.Writer) exporter
+}{
+	"csv":  {"text/csv", func(w io.Writer) exporter { return &csvExporter{w: csv.NewWriter(w)} }},
+	"json": {"application/json", func(w io.Writer) exporter { return &jsonExporter{w: w} }},
+	"ofx":  {"application/x-ofx", func(w io.Writer) exporter { return &ofxExporter{w: w} }},
+}
+
+// coinDecimals - decimal places of an amount in coin units
+var coinDecimals = int(math.Round(math.Log10(divisor)))
+
+// formatCoins - formats an amount in coin units
+func formatCoins(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', coinDecimals, 64)
+}
+
+// exportTransactions - streams the history of an address in the requested
+// format, from and to are inclusive dates (YYYY-MM-DD) and both optional
+func exportTransactions(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	format, ok := exportFormats[req.FormValue("format")]
+	if !ok {
+		res.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(res).Encode(jsonResponse{Status: "Unknown Export Format"})
+		return
+	}
+	var from, to time.Time
+	var err error
+	if v := req.FormValue("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(res).Encode(jsonResponse{Status: "Incorrect Date Format"})
+			return
+		}
+	}
+	if v := req.FormValue("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(res).Encode(jsonResponse{Status: "Incorrect Date Format"})
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	var fromArg, toArg interface{}
+	if !from.IsZero() {
+		fromArg = from
+	}
+	if !to.IsZero() {
+		toArg = to
+	}
+
+	address := p.ByName("address")
+	rows, err := walletDB.Query(`SELECT id, COALESCE(block_time, 'epoch'::timestamp), hash, dest, paymentID,
+				amount, fee, fusion, block_height
+			FROM transactions WHERE addr_id = (SELECT id FROM addresses WHERE address = $1)
+			AND ($2::timestamp IS NULL OR block_time >= $2) AND ($3::timestamp IS NULL OR block_time < $3)
+			ORDER BY id;`, address, fromArg, toArg)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(res).Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	res.Header().Set("Content-Type", format.contentType)
+	out := format.create(res)
+	flusher, _ := res.(http.Flusher)
+	if err = out.begin(address, from, to); err != nil {
+		fmt.Println("export:", err)
+		return
+	}
+	for n := 1; rows.Next(); n++ {
+		r := &exportRow{}
+		var fusion bool
+		err = rows.Scan(&r.ID, &r.time, &r.Hash, &r.Destination, &r.PaymentID,
+			&r.amount, &r.fee, &fusion, &r.BlockHeight)
+		if err != nil {
+			fmt.Println("export:", err)
+			return
+		}
+		r.Hash = strings.TrimSpace(r.Hash)
+		r.Destination = strings.TrimSpace(r.Destination)
+		r.PaymentID = strings.TrimSpace(r.PaymentID)
+		switch {
+		case fusion:
+			r.Direction = directionFusion
+		case r.Destination == "":
+			r.Direction = directionIn
+		default:
+			r.Direction = directionOut
+		}
+		r.Date = r.time.Format("2006-01-02 15:04:05")
+		r.Amount = formatCoins(r.amount)
+		r.Fee = formatCoins(r.fee)
+		if err = out.row(r); err != nil {
+			fmt.Println("export:", err)
+			return
+		}
+		if flusher != nil && n%exportFlushRows == 0 {
+			flusher.Flush()
+		}
+	}
+	if err = rows.Err(); err != nil {
+		// the statement is cut short rather than ended, so it can't be
+		// mistaken for a complete one
+		fmt.Println("export:", err)
+		return
+	}
+	if err = out.end(); err != nil {
+		fmt.Println("export:", err)
+	}
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) begin(address string, from, to time.Time) error {
+	return e.w.Write([]string{"date", "hash", "direction", "destination", "payment_id",
+		"amount", "fee", "block_height"})
+}
+
+func (e *csvExporter) row(r *exportRow) error {
+	return e.w.Write([]string{r.Date, r.Hash, r.Direction, r.Destination, r.PaymentID,
+		r.Amount, r.Fee, strconv.FormatInt(r.BlockHeight, 10)})
+}
+
+func (e *csvExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonExporter struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonExporter) begin(address string, from, to time.Time) error {
+	_, err := io.WriteString(e.w, "[\n")
+	return err
+}
+
+func (e *jsonExporter) row(r *exportRow) error {
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ",\n"); err != nil {
+			return err
+		}
+	}
+	e.count++
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonExporter) end() error {
+	_, err := io.WriteString(e.w, "\n]\n")
+	return err
+}
+
+// ofxExporter - OFX 2.1 bank statement. TRTL has no ISO 4217 code so the
+// statement uses XXX, amounts of withdrawals include their fee.
+type ofxExporter struct {
+	w       io.Writer
+	balance float64 // at the end of the statement
+	asOf    time.Time
+}
+
+// ofxTime - OFX datetime
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405")
+}
+
+// ofxText - escapes s for an OFX element, cut to max characters
+func ofxText(s string, max int) string {
+	if len(s) > max {
+		s = s[:max]
+	}
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (e *ofxExporter) begin(address string, from, to time.Time) error {
+	var toArg interface{}
+	if !to.IsZero() {
+		toArg = to
+	}
+	err := walletDB.QueryRow(`SELECT COALESCE(sum(CASE WHEN fusion THEN 0 WHEN trim(dest) = '' THEN amount
+				ELSE -(amount + fee) END), 0)
+			FROM transactions WHERE addr_id = (SELECT id FROM addresses WHERE address = $1)
+			AND ($2::timestamp IS NULL OR block_time < $2);`, address, toArg).Scan(&e.balance)
+	if err != nil {
+		return err
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	e.asOf = to
+	_, err = fmt.Fprintf(e.w, `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>1</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>XXX</CURDEF>
+<BANKACCTFROM><BANKID>SHELLNET</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>
+`, ofxTime(time.Now()), ofxText(address, 22), ofxTime(from), ofxTime(to))
+	return err
+}
+
+func (e *ofxExporter) row(r *exportRow) error {
+	trnType, amount := "CREDIT", r.amount
+	switch r.Direction {
+	case directionOut:
+		trnType, amount = "DEBIT", -(r.amount + r.fee)
+	case directionFusion:
+		trnType = "OTHER"
+	}
+	memo := r.Hash
+	if r.PaymentID != "" {
+		memo += " payment id " + r.PaymentID
+	}
+	if r.fee > 0 {
+		memo += " fee " + r.Fee
+	}
+	name := r.Destination
+	if name == "" {
+		name = r.Direction
+	}
+	_, err := fmt.Fprintf(e.w, `<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT>
+<FITID>%d</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>
+`, trnType, ofxTime(r.time), formatCoins(amount), r.ID, ofxText(name, 32), ofxText(memo, 255))
+	return err
+}
+
+func (e *ofxExporter) end() error {
+	_, err := fmt.Fprintf(e.w, `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`, formatCoins(e.balance), ofxTime(e.asOf))
+	return err
+}
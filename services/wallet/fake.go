@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fakeFaucet       = 100000 // credited to every address created by the fake
+	fakeMaxTransfers = 10     // larger transactions are rejected as too big
+	fakeFusionInputs = 12     // outputs needed for a fusion transaction
+	fakeBlockTime    = 30 * time.Second
+)
+
+// fakeBackend - in-memory WalletBackend, lets the wallet service run
+// without a turtle-service instance. Set WALLET_BACKEND=fake to use it.
+type fakeBackend struct {
+	mux       sync.Mutex
+	viewKey   string
+	addresses map[string]*fakeAddress
+	blocks    []Block
+	mempool   []Transaction
+	delayed   map[string]Transaction
+	lastBlock time.Time
+}
+
+type fakeAddress struct {
+	keys    SpendKeys
+	balance int64
+	outputs int64 // every incoming transfer adds one, a fusion leaves one
+}
+
+// newFakeBackend - creates an empty fake container with a genesis block
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		viewKey:   randomHex(32),
+		addresses: map[string]*fakeAddress{},
+		delayed:   map[string]Transaction{},
+		blocks:    []Block{{BlockHash: randomHex(32)}},
+		lastBlock: time.Now(),
+	}
+}
+
+// address - the standard address of spendPublicKey in this container. The
+// fake has no curve arithmetic, its public keys are hashes of the secret ones.
+func (f *fakeBackend) address(spendPublicKey string) string {
+	address, _ := encodeAddress(spendPublicKey, fakePublicKey(f.viewKey), "")
+	return address
+}
+
+// fakePublicKey - stands in for the public key of a secret key
+func fakePublicKey(secretKey string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(secretKey)))
+	return hex.EncodeToString(sum[:])
+}
+
+// mine - appends a block holding the mempool and txs, caller must hold the lock
+func (f *fakeBackend) mine(txs ...Transaction) {
+	height := int64(len(f.blocks))
+	txs = append(f.mempool, txs...)
+	for i := range txs {
+		txs[i].BlockIndex = height
+		txs[i].Timestamp = time.Now().Unix()
+		if txs[i].TransactionHash == "" {
+			txs[i].TransactionHash = randomHex(32)
+		}
+	}
+	f.blocks = append(f.blocks, Block{BlockHash: randomHex(32), Transactions: txs})
+	f.mempool = nil
+	f.lastBlock = time.Now()
+}
+
+// tick - mines the mempool once fakeBlockTime has passed, caller must hold the lock
+func (f *fakeBackend) tick() {
+	if len(f.mempool) > 0 && time.Since(f.lastBlock) >= fakeBlockTime {
+		f.mine()
+	}
+}
+
+// CreateAddress - creates an address and credits it with fakeFaucet
+func (f *fakeBackend) CreateAddress(ctx context.Context) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	keys := SpendKeys{SpendSecretKey: randomHex(32)}
+	keys.SpendPublicKey = fakePublicKey(keys.SpendSecretKey)
+	address := f.address(keys.SpendPublicKey)
+	f.addresses[address] = &fakeAddress{
+		keys:    keys,
+		balance: fakeFaucet,
+		outputs: 1,
+	}
+	f.mine(Transaction{
+		Amount:    fakeFaucet,
+		Transfers: []Transfer{{Address: address, Amount: fakeFaucet}},
+	})
+	return address, nil
+}
+
+// ImportAddress - adds the address derived from spendSecretKey
+func (f *fakeBackend) ImportAddress(ctx context.Context, spendSecretKey string, scanHeight int64) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	keys := SpendKeys{SpendSecretKey: spendSecretKey, SpendPublicKey: fakePublicKey(spendSecretKey)}
+	return f.addExisting(f.address(keys.SpendPublicKey), keys, scanHeight)
+}
+
+// CreateTrackingAddress - adds the address derived from spendPublicKey, the
+// fake does not tell tracking containers from others
+func (f *fakeBackend) CreateTrackingAddress(ctx context.Context, spendPublicKey string, scanHeight int64) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	keys := SpendKeys{SpendPublicKey: spendPublicKey}
+	return f.addExisting(f.address(spendPublicKey), keys, scanHeight)
+}
+
+// addExisting - adds an address of an existing wallet. The fake has no real
+// history for it, so a faucet deposit is put in the block at scanHeight as
+// if walletd had found it there. Caller must hold the lock.
+func (f *fakeBackend) addExisting(address string, keys SpendKeys, scanHeight int64) (string, error) {
+	if _, ok := f.addresses[address]; ok {
+		return "", errors.New("Address already exists")
+	}
+	f.addresses[address] = &fakeAddress{keys: keys, balance: fakeFaucet, outputs: 1}
+	deposit := Transaction{
+		TransactionHash: randomHex(32),
+		Amount:          fakeFaucet,
+		Transfers:       []Transfer{{Address: address, Amount: fakeFaucet}},
+	}
+	if scanHeight >= int64(len(f.blocks)) {
+		f.mine(deposit)
+		return address, nil
+	}
+	block := &f.blocks[scanHeight]
+	deposit.BlockIndex = scanHeight
+	deposit.Timestamp = time.Now().Unix()
+	block.Transactions = append(block.Transactions[:len(block.Transactions):len(block.Transactions)], deposit)
+	return address, nil
+}
+
+// CreateIntegratedAddress - returns address with paymentID embedded
+func (f *fakeBackend) CreateIntegratedAddress(ctx context.Context, address, paymentID string) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if _, ok := f.addresses[address]; !ok {
+		return "", errors.New("Address not found in container")
+	}
+	addr, err := decodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return encodeAddress(addr.SpendPublicKey, addr.ViewPublicKey, paymentID)
+}
+
+// DeleteAddress - removes an address
+func (f *fakeBackend) DeleteAddress(ctx context.Context, address string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if _, ok := f.addresses[address]; !ok {
+		return errors.New("Address not found in container")
+	}
+	delete(f.addresses, address)
+	return nil
+}
+
+// GetBalance - gets the balance of an address, nothing is ever locked
+func (f *fakeBackend) GetBalance(ctx context.Context, address string) (*Balance, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	addr, ok := f.addresses[address]
+	if !ok {
+		return nil, errors.New("Address not found in container")
+	}
+	return &Balance{AvailableBalance: addr.balance}, nil
+}
+
+// GetStatus - the fake container is always synced
+func (f *fakeBackend) GetStatus(ctx context.Context) (*Status, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.tick()
+	height := int64(len(f.blocks))
+	return &Status{
+		BlockCount:            height,
+		KnownBlockCount:       height,
+		LocalDaemonBlockCount: height,
+		LastBlockHash:         f.blocks[height-1].BlockHash,
+	}, nil
+}
+
+// GetTransactions - returns the blocks in [firstBlockIndex, firstBlockIndex+blockCount)
+func (f *fakeBackend) GetTransactions(ctx context.Context, firstBlockIndex, blockCount int64) ([]Block, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.tick()
+	if firstBlockIndex < 0 || firstBlockIndex >= int64(len(f.blocks)) {
+		return nil, errors.New("Wrong block index")
+	}
+	last := firstBlockIndex + blockCount
+	if last > int64(len(f.blocks)) {
+		last = int64(len(f.blocks))
+	}
+	blocks := make([]Block, last-firstBlockIndex)
+	copy(blocks, f.blocks[firstBlockIndex:last])
+	return blocks, nil
+}
+
+// GetBlockHashes - returns the hashes of the blocks in [firstBlockIndex, firstBlockIndex+blockCount)
+func (f *fakeBackend) GetBlockHashes(ctx context.Context, firstBlockIndex, blockCount int64) ([]string, error) {
+	blocks, err := f.GetTransactions(ctx, firstBlockIndex, blockCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(blocks))
+	for i, block := range blocks {
+		hashes[i] = block.BlockHash
+	}
+	return hashes, nil
+}
+
+// GetUnconfirmedTransactionHashes - hashes of the transactions in the mempool
+func (f *fakeBackend) GetUnconfirmedTransactionHashes(ctx context.Context, addresses []string) ([]string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.tick()
+	hashes := []string{}
+	for _, tx := range f.mempool {
+		hashes = append(hashes, tx.TransactionHash)
+	}
+	return hashes, nil
+}
+
+// GetTransaction - finds a transaction among the delayed ones, the mempool or the chain
+func (f *fakeBackend) GetTransaction(ctx context.Context, hash string) (*Transaction, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if tx, ok := f.delayed[hash]; ok {
+		return &tx, nil
+	}
+	for _, tx := range f.mempool {
+		if tx.TransactionHash == hash {
+			return &tx, nil
+		}
+	}
+	for _, block := range f.blocks {
+		for _, tx := range block.Transactions {
+			if tx.TransactionHash == hash {
+				return &tx, nil
+			}
+		}
+	}
+	return nil, errors.New("Object not found")
+}
+
+// build - checks a send and turns it into a transaction spending the whole
+// balance of the source, the rest comes back as change. Caller must hold the lock.
+func (f *fakeBackend) build(tx *TransactionRequest) (*Transaction, error) {
+	if len(tx.Addresses) != 1 {
+		return nil, errors.New("Fake backend only supports a single source address")
+	}
+	src, ok := f.addresses[tx.Addresses[0]]
+	if !ok {
+		return nil, errors.New("Address not found in container")
+	}
+	if len(tx.Transfers) > fakeMaxTransfers {
+		return nil, errors.New("Transaction size is too big")
+	}
+	total := tx.Fee
+	paymentID := tx.PaymentID
+	transfers := []Transfer{{Address: tx.Addresses[0], Amount: -src.balance}}
+	for _, dest := range tx.Transfers {
+		if dest.Amount <= 0 {
+			return nil, errors.New("Wrong amount")
+		}
+		// like walletd, integrated addresses are paid to their standard
+		// address with their payment id
+		addr, err := decodeAddress(dest.Address)
+		if err != nil {
+			return nil, errors.New("Bad address")
+		}
+		if addr.integrated() {
+			if paymentID != "" && !strings.EqualFold(paymentID, addr.PaymentID) {
+				return nil, errors.New("Conflicting payment ID")
+			}
+			paymentID = addr.PaymentID
+		}
+		total += dest.Amount
+		transfers = append(transfers, Transfer{Address: addr.standard(), Amount: dest.Amount})
+	}
+	if total > src.balance {
+		return nil, errors.New("Wrong amount")
+	}
+	if change := src.balance - total; change > 0 {
+		transfers = append(transfers, Transfer{Type: transferTypeChange, Address: tx.Addresses[0], Amount: change})
+	}
+	return &Transaction{
+		TransactionHash: randomHex(32),
+		Amount:          -total,
+		Fee:             tx.Fee,
+		UnlockTime:      tx.UnlockTime,
+		Extra:           tx.Extra,
+		PaymentID:       paymentID,
+		Transfers:       transfers,
+	}, nil
+}
+
+// relay - moves the funds of a built transaction and puts it in the
+// mempool, caller must hold the lock
+func (f *fakeBackend) relay(tx *Transaction) {
+	for _, t := range tx.Transfers {
+		if addr, ok := f.addresses[t.Address]; ok {
+			addr.balance += t.Amount
+			if t.Amount > 0 {
+				addr.outputs++
+			}
+		}
+	}
+	f.mempool = append(f.mempool, *tx)
+}
+
+// SendTransaction - moves funds and puts the transaction in the mempool
+func (f *fakeBackend) SendTransaction(ctx context.Context, tx *TransactionRequest) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	built, err := f.build(tx)
+	if err != nil {
+		return "", err
+	}
+	f.relay(built)
+	return built.TransactionHash, nil
+}
+
+// CreateDelayedTransaction - builds a transaction and keeps it until it is
+// sent or deleted, the fake does not lock the inputs
+func (f *fakeBackend) CreateDelayedTransaction(ctx context.Context, tx *TransactionRequest) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	built, err := f.build(tx)
+	if err != nil {
+		return "", err
+	}
+	f.delayed[built.TransactionHash] = *built
+	return built.TransactionHash, nil
+}
+
+// GetDelayedTransactionHashes - hashes of the delayed transactions
+func (f *fakeBackend) GetDelayedTransactionHashes(ctx context.Context) ([]string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	hashes := []string{}
+	for hash := range f.delayed {
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// SendDelayedTransaction - relays a delayed transaction if the source can still pay for it
+func (f *fakeBackend) SendDelayedTransaction(ctx context.Context, hash string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	tx, ok := f.delayed[hash]
+	if !ok {
+		return errors.New("Object not found")
+	}
+	src := f.addresses[tx.Transfers[0].Address]
+	if src == nil || src.balance != -tx.Transfers[0].Amount {
+		return errors.New("Balance changed since the transaction was created")
+	}
+	delete(f.delayed, hash)
+	f.relay(&tx)
+	return nil
+}
+
+// DeleteDelayedTransaction - forgets a delayed transaction
+func (f *fakeBackend) DeleteDelayedTransaction(ctx context.Context, hash string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if _, ok := f.delayed[hash]; !ok {
+		return errors.New("Object not found")
+	}
+	delete(f.delayed, hash)
+	return nil
+}
+
+// EstimateFusion - every output of the fake is below any threshold
+func (f *fakeBackend) EstimateFusion(ctx context.Context, threshold int64, addresses []string) (*FusionEstimate, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	estimate := &FusionEstimate{}
+	for _, address := range addresses {
+		addr, ok := f.addresses[address]
+		if !ok {
+			return nil, errors.New("Address not found in container")
+		}
+		estimate.TotalOutputCount += addr.outputs
+	}
+	estimate.FusionReadyCount = estimate.TotalOutputCount
+	return estimate, nil
+}
+
+// SendFusionTransaction - merges all outputs of the source address into one
+func (f *fakeBackend) SendFusionTransaction(ctx context.Context, tx *FusionRequest) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if len(tx.Addresses) != 1 || tx.DestinationAddress != tx.Addresses[0] {
+		return "", errors.New("Fake backend only fuses a single address into itself")
+	}
+	addr, ok := f.addresses[tx.Addresses[0]]
+	if !ok {
+		return "", errors.New("Address not found in container")
+	}
+	if addr.outputs < fakeFusionInputs {
+		return "", errors.New("Fusion transaction cannot be created: not enough outputs")
+	}
+	addr.outputs = 1
+	hash := randomHex(32)
+	f.mempool = append(f.mempool, Transaction{
+		TransactionHash: hash,
+		Transfers: []Transfer{
+			{Address: tx.Addresses[0], Amount: -addr.balance},
+			{Address: tx.Addresses[0], Amount: addr.balance},
+		},
+	})
+	return hash, nil
+}
+
+// GetViewKey - gets the container view key
+func (f *fakeBackend) GetViewKey(ctx context.Context) (string, error) {
+	return f.viewKey, nil
+}
+
+// GetSpendKeys - gets the spend keys of an address
+func (f *fakeBackend) GetSpendKeys(ctx context.Context, address string) (*SpendKeys, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	addr, ok := f.addresses[address]
+	if !ok {
+		return nil, errors.New("Address not found in container")
+	}
+	keys := addr.keys
+	return &keys, nil
+}
+
+// GetMnemonicSeed - encodes the spend key of an address with the loaded
+// word list
+func (f *fakeBackend) GetMnemonicSeed(ctx context.Context, address string) (string, error) {
+	keys, err := f.GetSpendKeys(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	return encodeMnemonic(keys.SpendSecretKey)
+}
+
+// Save - nothing to persist
+func (f *fakeBackend) Save(ctx context.Context) error {
+	return nil
+}
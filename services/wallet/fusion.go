@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	fusionAutoReady = 50 // fusion-ready outputs that trigger the automatic optimization
+	fusionMinReady  = 12 // fewer outputs can't make a fusion transaction
+	fusionMaxRounds = 5  // fusion transactions sent for an address in one go
+	fusionInterval  = 10 * time.Minute
+)
+
+// optimizer - consolidates the outputs of every address that collected
+// more than fusionAutoReady small outputs
+func (service *TurtleService) optimizer() {
+	for ; !shuttingDown(); pause(fusionInterval) {
+		if !service.isSynced() {
+			continue
+		}
+		addresses, err := containerAddresses(service.name)
+		if err != nil {
+			fmt.Println("fusion:", err)
+			continue
+		}
+		for _, address := range addresses {
+			ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+			hashes, err := optimizeAddress(ctx, service.backend, address, fusionAutoReady)
+			cancel()
+			if err != nil {
+				fmt.Println("fusion:", address, err)
+			}
+			if len(hashes) > 0 {
+				fmt.Println("fusion:", address, "sent", len(hashes), "transactions")
+			}
+		}
+	}
+}
+
+// containerAddresses - every address held by backend
+func containerAddresses(backend string) ([]string, error) {
+	rows, err := walletDB.Query("SELECT address FROM addresses WHERE backend = $1 ORDER BY id;", backend)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	addresses := []string{}
+	for rows.Next() {
+		var address string
+		if err = rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, strings.TrimSpace(address))
+	}
+	return addresses, rows.Err()
+}
+
+// optimizeAddress - sends fusion transactions for address while it has at
+// least minReady outputs to fuse, returns the hashes of the ones sent
+func optimizeAddress(ctx context.Context, backend WalletBackend, address string, minReady int64) ([]string, error) {
+	hashes := []string{}
+	for i := 0; i < fusionMaxRounds; i++ {
+		balance, err := backend.GetBalance(ctx, address)
+		if err != nil {
+			return hashes, err
+		}
+		if balance.AvailableBalance <= 0 {
+			break
+		}
+		// every available output is below the balance, so all of them qualify
+		threshold := balance.AvailableBalance + 1
+		estimate, err := backend.EstimateFusion(ctx, threshold, []string{address})
+		if err != nil {
+			return hashes, err
+		}
+		if estimate.FusionReadyCount < minReady {
+			break
+		}
+		hash, err := backend.SendFusionTransaction(ctx, &FusionRequest{
+			Threshold:          threshold,
+			Anonymity:          3, // mixin
+			Addresses:          []string{address},
+			DestinationAddress: address,
+		})
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// optimizeWallet - consolidates the outputs of an address on request
+func optimizeWallet(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	address := req.FormValue("address")
+	backend, err := senderFor(address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	hashes, err := optimizeAddress(ctx, backend, address, fusionMinReady)
+	if err != nil && len(hashes) == 0 {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if len(hashes) == 0 {
+		encoder.Encode(jsonResponse{Status: "Wallet is already optimized"})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK",
+		Data: map[string]interface{}{"transactionHashes": hashes}})
+}
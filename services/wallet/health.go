@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// health states of a backend, from best to worst
+const (
+	healthHealthy  = "healthy"  // answering and synced
+	healthSyncing  = "syncing"  // answering but behind the network
+	healthDegraded = "degraded" // missed pings, at most MaxPollingFailures in a row
+	healthDown     = "down"     // missed more than MaxPollingFailures pings in a row
+)
+
+var healthRank = map[string]int{healthHealthy: 0, healthSyncing: 1, healthDegraded: 2, healthDown: 3}
+
+const alertTimeout = 30 * time.Second // for alert hooks and the restart command
+
+var (
+	alertWebhook   string            // ALERT_WEBHOOK, posted a json event on every transition
+	alertCommand   string            // ALERT_COMMAND, run on every transition
+	restartCommand string            // RESTART_COMMAND, run while a backend stays down
+	restartAfter   = 5 * time.Minute // RESTART_AFTER, time down before a restart and between restarts
+)
+
+var alertClient = &http.Client{Timeout: alertTimeout}
+
+// healthStatus - health of a backend as reported by /health
+type healthStatus struct {
+	Backend         string    `json:"backend"`
+	State           string    `json:"state"`
+	Since           time.Time `json:"since"`
+	Failures        int       `json:"failures"`
+	BlockCount      int64     `json:"blockCount"`
+	KnownBlockCount int64     `json:"knownBlockCount"`
+	LastError       string    `json:"lastError,omitempty"`
+	Restarts        int       `json:"restarts"`
+}
+
+// healthEvent - body of the alert webhook
+type healthEvent struct {
+	Event  string       `json:"event"`
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	Time   time.Time    `json:"time"`
+	Health healthStatus `json:"health"`
+}
+
+// updateHealth - moves the backend through the health states after a ping,
+// raising alerts on transitions and restarting walletd when it stays down
+func (service *TurtleService) updateHealth(status *Status, err error) {
+	service.mux.Lock()
+	h := &service.health
+	prev := h.State
+	if err == nil {
+		service.LastBlock = status.BlockCount
+		service.PollingFailures = 0
+		h.State = healthHealthy
+		if status.BlockCount+1 < status.KnownBlockCount {
+			h.State = healthSyncing
+		}
+		h.LastError = ""
+		h.BlockCount, h.KnownBlockCount = status.BlockCount, status.KnownBlockCount
+	} else {
+		service.PollingFailures++
+		h.State = healthDegraded
+		if service.PollingFailures > service.MaxPollingFailures {
+			h.State = healthDown
+		}
+		h.LastError = err.Error()
+	}
+	h.Failures = service.PollingFailures
+	now := time.Now()
+	if h.State != prev {
+		h.Since = now
+	}
+	// the first ping only alerts when something is wrong
+	alert := h.State != prev && (service.pinged || h.State != healthHealthy)
+	service.pinged = true
+	restart := h.State == healthDown && restartCommand != "" &&
+		now.Sub(h.Since) >= restartAfter && now.Sub(service.lastRestart) >= restartAfter
+	if restart {
+		service.lastRestart = now
+		h.Restarts++
+	}
+	snapshot := *h
+	service.mux.Unlock()
+
+	if alert {
+		go raiseAlert(prev, snapshot)
+	}
+	if restart {
+		go service.restart(snapshot)
+	}
+}
+
+// healthState - current health of the backend
+func (service *TurtleService) healthState() healthStatus {
+	service.mux.Lock()
+	defer service.mux.Unlock()
+	return service.health
+}
+
+// senderFor - the walletd backend to send from address with. Every send
+// goes through it, so nothing reaches walletd unless it is healthy.
+func senderFor(address string) (WalletBackend, error) {
+	backend, err := spenderFor(address)
+	if err != nil {
+		return nil, err
+	}
+	service, err := serviceFor(address)
+	if err != nil {
+		return nil, err
+	}
+	if state := service.healthState().State; state != healthHealthy {
+		return nil, fmt.Errorf("Sending is disabled while the wallet is %s", state)
+	}
+	return backend, nil
+}
+
+// raiseAlert - reports a transition to the log, the alert webhook and the
+// alert command
+func raiseAlert(from string, h healthStatus) {
+	fmt.Println("health", h.Backend+":", from, "->", h.State, h.LastError)
+	if alertWebhook != "" {
+		body, _ := json.Marshal(healthEvent{Event: "health.changed", From: from, To: h.State,
+			Time: time.Now(), Health: h})
+		resp, err := alertClient.Post(alertWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Println("health alert:", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				fmt.Println("health alert:", resp.Status)
+			}
+		}
+	}
+	if alertCommand != "" {
+		runHook(alertCommand, h, "HEALTH_FROM="+from)
+	}
+}
+
+// restart - runs the restart command for a backend that stays down
+func (service *TurtleService) restart(h healthStatus) {
+	fmt.Println("health", h.Backend+": down since", h.Since.Format(time.RFC3339), "restarting")
+	runHook(restartCommand, h)
+}
+
+// runHook - runs command with sh, describing the backend in HEALTH_*
+// environment variables
+func runHook(command string, h healthStatus, env ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), append(env,
+		"HEALTH_BACKEND="+h.Backend,
+		"HEALTH_STATE="+h.State,
+		"HEALTH_ERROR="+h.LastError,
+	)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		fmt.Printf("health hook %q: %v\n%s", command, err, out)
+	}
+}
+
+// getHealth - health of every backend, the state is the worst of them. Answers
+// 503 when a backend is down so it can be used by external monitors.
+func getHealth(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	state := healthHealthy
+	backends := []healthStatus{}
+	for _, service := range serviceList {
+		h := service.healthState()
+		if healthRank[h.State] > healthRank[state] {
+			state = h.State
+		}
+		backends = append(backends, h)
+	}
+	if state == healthDown {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(res).Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{
+		"state":    state,
+		"backends": backends,
+	}})
+}
+
+// getAddressHealth - health of the backend holding an address
+func getAddressHealth(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	service, err := serviceFor(p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	h := service.healthState()
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{
+		"state":  h.State,
+		"health": h,
+	}})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize  = 15
+	maxPageSize      = 100
+	hashPrefixFormat = "^[a-fA-F0-9]{1,64}$"
+)
+
+// historyFilter - restricts the rows returned by getTransactions
+type historyFilter struct {
+	direction  string // in, out or fusion
+	minAmount  string // coin units
+	maxAmount  string
+	paymentID  string
+	hashPrefix string
+	from       time.Time // inclusive
+	to         time.Time // exclusive
+}
+
+// historyCursor - position in the history, pages are read newest first
+type historyCursor struct {
+	older bool  // rows older than id, otherwise newer
+	id    int64 // id of the last row seen in that direction
+}
+
+// encode - opaque form of the cursor handed to clients
+func (c *historyCursor) encode() string {
+	dir := "n"
+	if c.older {
+		dir = "o"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(dir + ":" + strconv.FormatInt(c.id, 10)))
+}
+
+// decodeCursor - parses a cursor made by encode, nil for the first page
+func decodeCursor(s string) (*historyCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	errCursor := errors.New("Incorrect Cursor")
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || (parts[0] != "o" && parts[0] != "n") {
+		return nil, errCursor
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || id < 0 {
+		return nil, errCursor
+	}
+	return &historyCursor{older: parts[0] == "o", id: id}, nil
+}
+
+// parseHistoryFilter - reads the filter parameters of a history request
+func parseHistoryFilter(req *http.Request) (*historyFilter, error) {
+	f := &historyFilter{
+		direction:  req.FormValue("direction"),
+		minAmount:  req.FormValue("min"),
+		maxAmount:  req.FormValue("max"),
+		paymentID:  strings.ToLower(strings.TrimSpace(req.FormValue("payment_id"))),
+		hashPrefix: strings.ToLower(strings.TrimSpace(req.FormValue("hash"))),
+	}
+	switch f.direction {
+	case "", directionIn, directionOut, directionFusion:
+	default:
+		return nil, errors.New("Incorrect Direction")
+	}
+	for _, amount := range []string{f.minAmount, f.maxAmount} {
+		if matched, _ := regexp.MatchString(amountFormat, amount); !matched && amount != "" {
+			return nil, errors.New("Incorrect Amount Format")
+		}
+	}
+	if matched, _ := regexp.MatchString(paymentIDFormat, f.paymentID); !matched && f.paymentID != "" {
+		return nil, errors.New("Incorrect Payment ID Format")
+	}
+	if matched, _ := regexp.MatchString(hashPrefixFormat, f.hashPrefix); !matched && f.hashPrefix != "" {
+		return nil, errors.New("Incorrect Hash Format")
+	}
+	var err error
+	if v := req.FormValue("from"); v != "" {
+		if f.from, err = time.Parse("2006-01-02", v); err != nil {
+			return nil, errors.New("Incorrect Date Format")
+		}
+	}
+	if v := req.FormValue("to"); v != "" {
+		if f.to, err = time.Parse("2006-01-02", v); err != nil {
+			return nil, errors.New("Incorrect Date Format")
+		}
+		f.to = f.to.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
+// parsePageSize - reads the limit parameter of a history request
+func parsePageSize(req *http.Request) (int, error) {
+	if req.FormValue("limit") == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(req.FormValue("limit"))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, errors.New("Page size must be between 1 and 100")
+	}
+	return limit, nil
+}
+
+// where - sql conditions for the filter on the transactions table aliased
+// t, the values are appended to args
+func (f *historyFilter) where(args []interface{}) (string, []interface{}) {
+	conds := []string{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	switch f.direction {
+	case directionIn:
+		conds = append(conds, "NOT t.fusion AND trim(t.dest) = ''")
+	case directionOut:
+		conds = append(conds, "NOT t.fusion AND trim(t.dest) != ''")
+	case directionFusion:
+		conds = append(conds, "t.fusion")
+	}
+	if f.minAmount != "" {
+		conds = append(conds, "t.amount >= "+arg(f.minAmount)+"::numeric")
+	}
+	if f.maxAmount != "" {
+		conds = append(conds, "t.amount <= "+arg(f.maxAmount)+"::numeric")
+	}
+	if f.paymentID != "" {
+		conds = append(conds, "lower(t.paymentID) = "+arg(f.paymentID))
+	}
+	if f.hashPrefix != "" {
+		// only hex characters get here, so there is nothing to escape for LIKE
+		conds = append(conds, "t.hash LIKE "+arg(f.hashPrefix+"%"))
+	}
+	if !f.from.IsZero() {
+		conds = append(conds, "t.block_time >= "+arg(f.from))
+	}
+	if !f.to.IsZero() {
+		conds = append(conds, "t.block_time < "+arg(f.to))
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(conds, " AND "), args
+}
+
+// queryHistory - a page of the history of address, newest first, with the
+// cursors of the pages before and after it, empty when there are none
+func queryHistory(address string, f *historyFilter, cursor *historyCursor, limit int) ([]transaction, string, string, error) {
+	args := []interface{}{address}
+	conds, args := f.where(args)
+	order := "DESC"
+	if cursor != nil {
+		args = append(args, cursor.id)
+		if cursor.older {
+			conds += " AND t.id < $" + strconv.Itoa(len(args))
+		} else {
+			conds += " AND t.id > $" + strconv.Itoa(len(args))
+			order = "ASC"
+		}
+	}
+	args = append(args, limit+1)
+	rows, err := walletDB.Query(`SELECT t.dest, t.hash, t.amount, t.paymentID, t.id, t.block_height, t.unlock_time,
+				t.fusion, COALESCE(to_char(t.block_time, 'YYYY-MM-DD HH24:MI:SS'), ''), `+contactName+`
+			FROM transactions t
+			WHERE t.addr_id = (SELECT id FROM addresses WHERE address = $1)`+conds+`
+			ORDER BY t.id `+order+` LIMIT $`+strconv.Itoa(len(args))+`;`, args...)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer rows.Close()
+
+	var tmp string
+	txs := make([]transaction, 0)
+	for rows.Next() {
+		tx := transaction{}
+		err := rows.Scan(&tmp, &tx.Hash, &tx.Amount, &tx.PaymentID, &tx.ID,
+			&tx.BlockHeight, &tx.UnlockTime, &tx.Fusion, &tx.Date, &tx.Contact)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if tmp[0] != ' ' {
+			tx.Destination = tmp
+		}
+		txs = append(txs, tx)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", "", err
+	}
+
+	more := len(txs) > limit
+	if more {
+		txs = txs[:limit]
+	}
+	if order == "ASC" {
+		for i, j := 0, len(txs)-1; i < j; i, j = i+1, j-1 {
+			txs[i], txs[j] = txs[j], txs[i]
+		}
+	}
+	if len(txs) == 0 {
+		return txs, "", "", nil
+	}
+
+	// a cursor was followed to get here, so there are rows on the other side
+	olderExist := more || (cursor != nil && !cursor.older)
+	newerExist := cursor != nil && (cursor.older || more)
+	var next, prev string
+	if olderExist {
+		id, _ := strconv.ParseInt(txs[len(txs)-1].ID, 10, 64)
+		next = (&historyCursor{older: true, id: id}).encode()
+	}
+	if newerExist {
+		id, _ := strconv.ParseInt(txs[0].ID, 10, 64)
+		prev = (&historyCursor{older: false, id: id}).encode()
+	}
+	return txs, next, prev, nil
+}
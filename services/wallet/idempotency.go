@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	requestKeyFormat = "^[a-fA-F0-9]{32}$"
+	requestKeyTTL    = "7 days" // keys older than this can be reused
+)
+
+var (
+	errSendInProgress = errors.New("This transaction is already being sent")
+	errKeyReused      = errors.New("Request key was already used for a different transaction")
+	errSendUnknown    = errors.New("Timed out waiting for walletd, the transaction may have been sent. " +
+		"Check your transaction history before sending again")
+)
+
+// sendRequest - a send_transaction call identified by its request key
+type sendRequest struct {
+	key       string
+	address   string
+	dest      string
+	amount    int64
+	paymentID string
+}
+
+// beginSend - claims the request key. If the key was used before, the
+// stored outcome is returned instead and the caller must not send again.
+func beginSend(r *sendRequest) (hash string, fresh bool, err error) {
+	walletDB.Exec("DELETE FROM send_requests WHERE created < now() - $1::interval;", requestKeyTTL)
+	result, err := walletDB.Exec(`INSERT INTO send_requests (request_key, address, dest, amount, paymentID)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING;`,
+		strings.ToLower(r.key), r.address, r.dest, float64(r.amount)/divisor, r.paymentID)
+	if err != nil {
+		return "", false, err
+	}
+	if n, _ := result.RowsAffected(); n == 1 {
+		return "", true, nil
+	}
+
+	var address, dest, paymentID string
+	var amount float64
+	var storedHash, storedErr sql.NullString
+	err = walletDB.QueryRow(`SELECT address, dest, amount, paymentID, hash, error FROM send_requests
+			WHERE request_key = $1;`, strings.ToLower(r.key)).Scan(
+		&address, &dest, &amount, &paymentID, &storedHash, &storedErr)
+	if err != nil {
+		return "", false, err
+	}
+	if strings.TrimSpace(address) != r.address || dest != r.dest || paymentID != r.paymentID ||
+		int64(math.Round(amount*divisor)) != r.amount {
+		return "", false, errKeyReused
+	}
+	switch {
+	case storedHash.Valid:
+		return strings.TrimSpace(storedHash.String), false, nil
+	case storedErr.Valid:
+		return "", false, errors.New(storedErr.String)
+	case isHeld(r.key):
+		return "", false, errWithdrawalHeld
+	default:
+		return "", false, errSendInProgress
+	}
+}
+
+// finishSend - stores the outcome of a claimed request
+func finishSend(r *sendRequest, hash string, sendErr error) {
+	var err error
+	if sendErr != nil {
+		_, err = walletDB.Exec("UPDATE send_requests SET error = $2 WHERE request_key = $1;",
+			strings.ToLower(r.key), sendErr.Error())
+	} else {
+		_, err = walletDB.Exec("UPDATE send_requests SET hash = $2 WHERE request_key = $1;",
+			strings.ToLower(r.key), hash)
+	}
+	if err != nil {
+		fmt.Println("send request", r.key, err)
+	}
+}
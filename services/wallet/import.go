@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// backfillBlocks - blocks fetched from walletd per round of a backfill
+const backfillBlocks = 1000
+
+var (
+	spendKeyFormat = regexp.MustCompile("^[0-9a-fA-F]{64}$")
+
+	errAddressRegistered = errors.New("Address already registered")
+)
+
+// importAddress - adds the address of an existing spend key or mnemonic seed
+// to a container. Transfers below the scanner checkpoint are backfilled
+// from scan_height, transfers past it are picked up by the scanner.
+func importAddress(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	spendKey, err := importKey(req.FormValue("spend_key"), req.FormValue("mnemonic"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	var scanHeight int64
+	if v := req.FormValue("scan_height"); v != "" {
+		if scanHeight, err = strconv.ParseInt(v, 10, 64); err != nil || scanHeight < 0 {
+			encoder.Encode(jsonResponse{Status: "Scan height must be a block number"})
+			return
+		}
+	}
+	order, err := assignOrder()
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	for _, service := range order {
+		var address string
+		address, err = service.backend.ImportAddress(ctx, spendKey, scanHeight)
+		if err != nil {
+			fmt.Println("import address", service.name+":", err)
+			continue
+		}
+		if err = registerExisting(service.name, address, false, scanHeight, nil); err != nil {
+			// don't leave a second copy of an address kept by another backend
+			if derr := service.backend.DeleteAddress(ctx, address); derr != nil {
+				fmt.Println("import address", service.name+":", derr)
+			}
+			break
+		}
+		data := map[string]interface{}{"address": address, "scanHeight": scanHeight}
+		encoder.Encode(jsonResponse{Status: "OK", Data: data})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: err.Error()})
+}
+
+// importKey - the spend secret key given either directly or as a mnemonic
+// seed
+func importKey(spendKey, mnemonic string) (string, error) {
+	spendKey = strings.TrimSpace(spendKey)
+	switch {
+	case spendKey != "" && strings.TrimSpace(mnemonic) != "":
+		return "", errors.New("Give either a spend key or a mnemonic seed")
+	case spendKey != "":
+		if !spendKeyFormat.MatchString(spendKey) {
+			return "", errors.New("Spend key must be 64 hex characters")
+		}
+		return strings.ToLower(spendKey), nil
+	case strings.TrimSpace(mnemonic) != "":
+		return decodeMnemonic(mnemonic)
+	}
+	return "", errors.New("Give a spend key or a mnemonic seed")
+}
+
+// registerExisting - stores the address of an existing wallet and
+// schedules the backfill of its history from scanHeight up to the
+// checkpoint of backend. then, if not nil, runs in the same sql transaction
+// with the id of the address. The checkpoint row is locked until the
+// address is stored, see recordBlocks.
+func registerExisting(backend, address string, watchOnly bool, scanHeight int64,
+	then func(tx *sql.Tx, addrID int) error) error {
+	tx, err := walletDB.Begin()
+	if err != nil {
+		return err
+	}
+	var checkpoint int64
+	err = tx.QueryRow("SELECT height FROM scan_checkpoint WHERE backend = $1 FOR UPDATE;",
+		backend).Scan(&checkpoint)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var registered bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM addresses WHERE address = $1);", address).Scan(&registered)
+	if err == nil && registered {
+		err = errAddressRegistered
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var addrID int
+	err = tx.QueryRow("INSERT INTO addresses (address, backend, watch_only) VALUES ($1, $2, $3) RETURNING id;",
+		address, backend, watchOnly).Scan(&addrID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if scanHeight < checkpoint {
+		_, err = tx.Exec(`INSERT INTO backfills (addr_id, backend, height, until_height)
+				VALUES ($1, $2, $3, $4);`, addrID, backend, scanHeight, checkpoint)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if then != nil {
+		if err = then(tx, addrID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// backfill - the part of an imported address's history left to scan
+type backfill struct {
+	addrID  int
+	address string
+	height  int64 // next block to scan
+	until   int64 // the scanner recorded the blocks from here on
+}
+
+// backfiller - scans the history of imported addresses below the checkpoint
+// they were imported at
+func (service *TurtleService) backfiller() {
+	for ; !shuttingDown(); pause(time.Duration(service.ScanInterval) * time.Millisecond) {
+		jobs, err := service.backfills()
+		if err != nil {
+			fmt.Println("backfill", service.name+":", err)
+			continue
+		}
+		for _, job := range jobs {
+			service.scanMux.Lock()
+			err = service.backfill(job)
+			service.scanMux.Unlock()
+			if err != nil {
+				fmt.Println("backfill", service.name, job.address+":", err)
+			}
+		}
+	}
+}
+
+// backfills - the unfinished backfills of the addresses of the service
+func (service *TurtleService) backfills() ([]backfill, error) {
+	rows, err := walletDB.Query(`SELECT b.addr_id, a.address, b.height, b.until_height
+			FROM backfills b JOIN addresses a ON a.id = b.addr_id
+			WHERE b.backend = $1 ORDER BY b.addr_id;`, service.name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []backfill{}
+	for rows.Next() {
+		var job backfill
+		if err = rows.Scan(&job.addrID, &job.address, &job.height, &job.until); err != nil {
+			return nil, err
+		}
+		job.address = strings.TrimSpace(job.address)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// backfill - records the transfers of one address in the next blocks of
+// its backfill, or drops the backfill once it reached its end
+func (service *TurtleService) backfill(job backfill) error {
+	if job.height >= job.until {
+		_, err := walletDB.Exec("DELETE FROM backfills WHERE addr_id = $1;", job.addrID)
+		if err == nil {
+			fmt.Println("backfill", service.name, job.address, "done")
+		}
+		return err
+	}
+	count := job.until - job.height
+	if count > backfillBlocks {
+		count = backfillBlocks
+	}
+	// walletd rescans the container from the scan height of an imported
+	// address, blocks it hasn't got back to yet lack its transfers
+	if synced := service.lastBlock() - job.height; count > synced {
+		count = synced
+	}
+	if count <= 0 {
+		return nil
+	}
+	ctx, cancel := service.timeout()
+	blocks, err := service.backend.GetTransactions(ctx, job.height, count)
+	cancel()
+	if err != nil {
+		return err
+	}
+	dbTx, err := walletDB.Begin()
+	if err != nil {
+		return err
+	}
+	for i := range blocks {
+		for j := range blocks[i].Transactions {
+			tx := &blocks[i].Transactions[j]
+			for _, e := range txEntries(tx) {
+				if e.address != job.address {
+					continue
+				}
+				if err = addTransaction(dbTx, e, tx); err != nil {
+					dbTx.Rollback()
+					return err
+				}
+			}
+		}
+	}
+	// a reorg rewinding the backfill meanwhile makes these rows stale
+	result, err := dbTx.Exec("UPDATE backfills SET height = $3 WHERE addr_id = $1 AND height = $2;",
+		job.addrID, job.height, job.height+count)
+	if err != nil {
+		dbTx.Rollback()
+		return err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		dbTx.Rollback()
+		return nil
+	}
+	return dbTx.Commit()
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -13,16 +17,25 @@ var (
 	rpcPort           int
 	rpcPwd            string
 	walletDB          *sql.DB
+
+	allowPrivateWebhooks bool // lets webhooks reach local stand-ins while testing
 )
 
 const (
 	// Forking config.
-	addressFormat          = "^TRTL([a-zA-Z0-9]{95}|[a-zA-Z0-9]{183})$"
+	addressPrefix          = 3914525 // This is 3914525 for TRTL, addresses start with TRTL
+	amountFormat           = "^[0-9]+\\.{0,1}[0-9]{0,2}$"
 	divisor        float64 = 100 // This is 100 for TRTL
 	transactionFee         = 10  // This is 10 for TRTL
+
+	confirmationsRequired = 3         // blocks until a transfer shows as confirmed
+	spendableAge          = 10        // blocks until an output can be spent, 10 for TRTL
+	maxBlockNumber        = 500000000 // unlock times below this are block heights
 )
 
-func init() {
+// configure - reads the environment, connects to the database and sets up
+// the backends, panics on a bad setting
+func configure() {
 	var err error
 
 	if dbUser = os.Getenv("DB_USER"); dbUser == "" {
@@ -51,15 +64,161 @@ func init() {
 	}
 
 	hostURI += hostPort
-	if rpcPwd = os.Getenv("RPC_PWD"); rpcPwd == "" {
-		panic("Set the RPC_PWD env variable")
+
+	if allowPrivateWebhooks = os.Getenv("WEBHOOK_ALLOW_PRIVATE") != ""; allowPrivateWebhooks {
+		println("Webhooks may post to private and loopback addresses")
+	}
+
+	configs := []backendConfig{{name: defaultBackend, host: "localhost"}}
+	if v := os.Getenv("RPC_BACKENDS"); v != "" {
+		if configs, err = parseBackends(v); err != nil {
+			panic(err)
+		}
+	}
+	switch shardPolicy = os.Getenv("BACKEND_POLICY"); shardPolicy {
+	case "":
+		shardPolicy = policyLeastLoaded
+	case policyLeastLoaded, policyRoundRobin:
+	default:
+		panic("BACKEND_POLICY must be least-loaded or round-robin")
+	}
+
+	fake := os.Getenv("WALLET_BACKEND") == "fake"
+	if fake {
+		println("Using in-memory fake walletd backends")
+	} else {
+		rpcPwd = os.Getenv("RPC_PWD")
+		if rpcPort, err = strconv.Atoi(os.Getenv("RPC_PORT")); rpcPort == 0 || err != nil {
+			rpcPort = 8070
+			println("Using default RPC_PORT - 8070")
+		}
+	}
+	for _, c := range configs {
+		if fake {
+			addService(NewService(c.name, newFakeBackend()))
+			continue
+		}
+		pwd := os.Getenv("RPC_PWD_" + strings.ToUpper(c.name))
+		if pwd == "" {
+			pwd = rpcPwd
+		}
+		if pwd == "" {
+			panic("Set the RPC_PWD env variable")
+		}
+		port := c.port
+		if port == 0 {
+			port = rpcPort
+		}
+		addService(NewService(c.name, newRPCClient(c.host, port, pwd)))
+		fmt.Println("backend", c.name, "at", c.host+":"+strconv.Itoa(port))
+	}
+	if v := os.Getenv("WATCH_BACKENDS"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			service, ok := services[strings.TrimSpace(name)]
+			if !ok {
+				panic("WATCH_BACKENDS: backend " + name + " is not configured")
+			}
+			service.watchOnly = true
+			if fake {
+				fmt.Println("fake watch backend", service.name, "has the view key", service.backend.(*fakeBackend).viewKey)
+			}
+		}
+		spending := 0
+		for _, service := range serviceList {
+			if !service.watchOnly {
+				spending++
+			}
+		}
+		if spending == 0 {
+			panic("WATCH_BACKENDS: at least one backend has to take new addresses")
+		}
+	}
+	for _, c := range configs {
+		path := os.Getenv("CONTAINER_FILE_" + strings.ToUpper(c.name))
+		if path == "" && c.name == defaultBackend {
+			path = os.Getenv("CONTAINER_FILE")
+		}
+		if path != "" {
+			containerFiles[c.name] = path
+		}
+	}
+
+	if v := os.Getenv("WITHDRAWAL_HOLD_THRESHOLD"); v != "" {
+		if matched, _ := regexp.MatchString(amountFormat, v); !matched {
+			panic("WITHDRAWAL_HOLD_THRESHOLD must be an amount like 1000.00")
+		}
+		holdThreshold, _ = parseAmount(v)
+		fmt.Println("Sends above", v, "wait for approval")
+	}
+	if v := os.Getenv("POLICY_COOLDOWN"); v != "" {
+		if spendCooldown, err = time.ParseDuration(v); err != nil || spendCooldown < 0 {
+			panic("POLICY_COOLDOWN must be a duration like 24h")
+		}
 	}
-	if rpcPort, err = strconv.Atoi(os.Getenv("RPC_PORT")); rpcPort == 0 || err != nil {
-		rpcPort = 8070
-		println("Using default RPC_PORT - 8070")
+
+	if v := os.Getenv("MNEMONIC_WORDLIST"); v != "" {
+		err = loadWordlist(v)
+	} else {
+		err = useWordlist("built-in word list", mnemonicEnglish)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	alertWebhook = os.Getenv("ALERT_WEBHOOK")
+	alertCommand = os.Getenv("ALERT_COMMAND")
+	restartCommand = os.Getenv("RESTART_COMMAND")
+	if v := os.Getenv("RESTART_AFTER"); v != "" {
+		if restartAfter, err = time.ParseDuration(v); err != nil || restartAfter <= 0 {
+			panic("RESTART_AFTER must be a positive duration like 5m")
+		}
+	}
+
+	if v := os.Getenv("BACKUP_KEY"); v != "" {
+		if backupKey, err = hex.DecodeString(v); err != nil || len(backupKey) != 32 {
+			panic("BACKUP_KEY must be 64 hex characters")
+		}
+	}
+	if backupDir = os.Getenv("BACKUP_DIR"); backupDir != "" {
+		if backupKey == nil {
+			panic("Set the BACKUP_KEY env variable to encrypt backups")
+		}
+		if v := os.Getenv("BACKUP_INTERVAL"); v != "" {
+			if backupInterval, err = time.ParseDuration(v); err != nil || backupInterval <= 0 {
+				panic("BACKUP_INTERVAL must be a positive duration like 24h")
+			}
+		}
+		if v := os.Getenv("BACKUP_KEEP"); v != "" {
+			if backupKeep, err = strconv.Atoi(v); err != nil || backupKeep < 1 {
+				panic("BACKUP_KEEP must be at least 1")
+			}
+		}
+		if v := os.Getenv("BACKUP_KEEP_WEEKLY"); v != "" {
+			if backupKeepWeekly, err = strconv.Atoi(v); err != nil || backupKeepWeekly < 0 {
+				panic("BACKUP_KEEP_WEEKLY must be 0 or more")
+			}
+		}
+	}
+	if v := os.Getenv("PG_DUMP"); v != "" {
+		pgDump = v
+	}
+	if v := os.Getenv("PG_RESTORE"); v != "" {
+		pgRestore = v
 	}
+}
 
-	wallet := NewService()
-	wallet.RPCPassword = rpcPwd
-	go wallet.Start()
+// startServices - starts the scanners and background workers of the service
+func startServices() {
+	for _, service := range serviceList {
+		if err := service.Start(); err != nil {
+			panic(err)
+		}
+	}
+	runWorker(webhookDispatcher)
+	runWorker(spendsCollector)
+	if backupDir != "" {
+		runWorker(backupWorker)
+	} else {
+		println("Backups are off, set BACKUP_DIR and BACKUP_KEY to turn them on")
+	}
 }
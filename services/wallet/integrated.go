@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const paymentIDFormat = "^[a-fA-F0-9]{64}$"
+
+type integratedAddress struct {
+	Address      string
+	PaymentID    string
+	Label        string
+	Created      string
+	Transactions []transaction
+}
+
+// newIntegratedAddress - creates an integrated address for address with the
+// supplied payment id, or a random one if none is given
+func newIntegratedAddress(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	address := req.FormValue("address")
+	paymentID := req.FormValue("payment_id")
+	label := strings.TrimSpace(req.FormValue("label"))
+	if paymentID == "" {
+		paymentID = randomHex(32)
+	}
+	if matched, _ := regexp.MatchString(paymentIDFormat, paymentID); !matched {
+		encoder.Encode(jsonResponse{Status: "Incorrect Payment ID Format"})
+		return
+	}
+	if len(label) > 64 {
+		encoder.Encode(jsonResponse{Status: "Label is too long"})
+		return
+	}
+	backend, err := backendFor(address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	integrated, err := backend.CreateIntegratedAddress(ctx, address, paymentID)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	_, err = walletDB.Exec(`INSERT INTO integrated_addresses (addr_id, address, paymentID, label)
+			VALUES ((SELECT id FROM addresses WHERE address = $1), $2, $3, $4)
+			ON CONFLICT (address) DO UPDATE SET label = EXCLUDED.label;`,
+		address, integrated, strings.ToLower(paymentID), label)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{
+		"integratedAddress": integrated,
+		"paymentID":         strings.ToLower(paymentID),
+	}})
+}
+
+// getIntegratedAddresses - lists the integrated addresses of an address and
+// the incoming transactions that carried their payment id
+func getIntegratedAddresses(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	rows, err := walletDB.Query(`SELECT address, paymentID, label, to_char(created, 'YYYY-MM-DD HH24:MI')
+								 FROM integrated_addresses
+								 WHERE addr_id = (SELECT id FROM addresses WHERE address = $1) ORDER BY id DESC;`,
+		p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	addrs := make([]integratedAddress, 0)
+	for rows.Next() {
+		ia := integratedAddress{Transactions: []transaction{}}
+		if err = rows.Scan(&ia.Address, &ia.PaymentID, &ia.Label, &ia.Created); err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		addrs = append(addrs, ia)
+	}
+	if err = rows.Err(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+
+	for i := range addrs {
+		addrs[i].Transactions, err = paymentsByID(p.ByName("address"), addrs[i].PaymentID)
+		if err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+	}
+	encoder.Encode(jsonResponse{Status: "OK",
+		Data: map[string]interface{}{"integratedAddresses": addrs}})
+}
+
+// paymentsByID - incoming transactions to address with the given payment id
+func paymentsByID(address, paymentID string) ([]transaction, error) {
+	rows, err := walletDB.Query(`SELECT hash, amount, paymentID, id FROM transactions
+								 WHERE addr_id = (SELECT id FROM addresses WHERE address = $1)
+								 AND trim(dest) = '' AND lower(paymentID) = lower($2) ORDER BY id DESC;`,
+		address, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	txs := make([]transaction, 0)
+	for rows.Next() {
+		tx := transaction{}
+		if err = rows.Scan(&tx.Hash, &tx.Amount, &tx.PaymentID, &tx.ID); err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, rows.Err()
+}
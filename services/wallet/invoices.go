@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	invoiceIDFormat   = "^[a-f0-9]{32}$"
+	maxInvoiceExpiry  = 30 * 24 // hours
+	maxInvoiceDescLen = 256
+)
+
+// invoice states
+const (
+	invoiceOpen      = "open"
+	invoiceUnderpaid = "underpaid"
+	invoicePaid      = "paid"
+	invoiceOverpaid  = "overpaid"
+	invoiceExpired   = "expired"
+)
+
+type invoice struct {
+	ID                string
+	Address           string
+	IntegratedAddress string
+	PaymentID         string
+	Amount            string
+	Received          string
+	Description       string
+	Status            string
+	Expires           string
+	Created           string
+	Paid              string
+}
+
+// invoiceColumns - selected by every invoice query, in the order scanInvoice expects.
+// An open invoice past its expiry is reported as expired.
+const invoiceColumns = `i.public_id, a.address, i.integrated_address, i.paymentID, i.amount, i.received,
+		i.description, CASE WHEN i.status = 'open' AND i.expires < now() THEN 'expired' ELSE i.status END,
+		to_char(i.expires, 'YYYY-MM-DD HH24:MI'), to_char(i.created, 'YYYY-MM-DD HH24:MI'),
+		COALESCE(to_char(i.paid, 'YYYY-MM-DD HH24:MI'), '')`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanInvoice - reads a row selected with invoiceColumns
+func scanInvoice(row rowScanner) (*invoice, error) {
+	inv := &invoice{}
+	err := row.Scan(&inv.ID, &inv.Address, &inv.IntegratedAddress, &inv.PaymentID, &inv.Amount,
+		&inv.Received, &inv.Description, &inv.Status, &inv.Expires, &inv.Created, &inv.Paid)
+	if err != nil {
+		return nil, err
+	}
+	inv.Address = strings.TrimSpace(inv.Address)
+	inv.IntegratedAddress = strings.TrimSpace(inv.IntegratedAddress)
+	inv.PaymentID = strings.TrimSpace(inv.PaymentID)
+	return inv, nil
+}
+
+// invoiceStatus - state of an invoice for amount once received has arrived,
+// both in atomic units
+func invoiceStatus(amount, received int64) string {
+	switch {
+	case received == 0:
+		return invoiceOpen
+	case received < amount:
+		return invoiceUnderpaid
+	case received == amount:
+		return invoicePaid
+	default:
+		return invoiceOverpaid
+	}
+}
+
+// newInvoice - creates an invoice for address with its own payment id
+func newInvoice(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	address := req.FormValue("address")
+	description := strings.TrimSpace(req.FormValue("description"))
+	if matched, _ := regexp.MatchString(amountFormat, req.FormValue("amount")); !matched {
+		encoder.Encode(jsonResponse{Status: "Incorrect Amount Format"})
+		return
+	}
+	amount, err := parseAmount(req.FormValue("amount"))
+	if err != nil || amount <= 0 {
+		encoder.Encode(jsonResponse{Status: "Incorrect Amount Format"})
+		return
+	}
+	expiry, err := strconv.Atoi(req.FormValue("expires_in"))
+	if err != nil || expiry < 1 || expiry > maxInvoiceExpiry {
+		encoder.Encode(jsonResponse{Status: "Expiry must be between 1 hour and 30 days"})
+		return
+	}
+	if len(description) > maxInvoiceDescLen {
+		encoder.Encode(jsonResponse{Status: "Description is too long"})
+		return
+	}
+
+	backend, err := backendFor(address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	paymentID := randomHex(32)
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	integrated, err := backend.CreateIntegratedAddress(ctx, address, paymentID)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	id := randomHex(16)
+	_, err = walletDB.Exec(`INSERT INTO invoices (public_id, addr_id, integrated_address, paymentID,
+				amount, description, expires)
+			VALUES ($1, (SELECT id FROM addresses WHERE address = $2), $3, $4, $5, $6,
+				now() + $7 * interval '1 hour');`,
+		id, address, integrated, paymentID, float64(amount)/divisor, description, expiry)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"id": id}})
+}
+
+// getInvoices - lists the invoices of an address, newest first
+func getInvoices(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	rows, err := walletDB.Query(`SELECT `+invoiceColumns+` FROM invoices i
+			JOIN addresses a ON a.id = i.addr_id WHERE a.address = $1 ORDER BY i.id DESC;`,
+		p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	defer rows.Close()
+	invoices := make([]*invoice, 0)
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		invoices = append(invoices, inv)
+	}
+	if err = rows.Err(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"invoices": invoices}})
+}
+
+// getInvoice - gets a single invoice by its public id
+func getInvoice(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	if matched, _ := regexp.MatchString(invoiceIDFormat, p.ByName("id")); !matched {
+		encoder.Encode(jsonResponse{Status: "Invoice not found"})
+		return
+	}
+	inv, err := scanInvoice(walletDB.QueryRow(`SELECT `+invoiceColumns+` FROM invoices i
+			JOIN addresses a ON a.id = i.addr_id WHERE i.public_id = $1;`, p.ByName("id")))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: "Invoice not found"})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"invoice": inv}})
+}
+
+// matchInvoices - recomputes what the invoices of address with paymentID
+// received and updates their status, an empty address matches every
+// invoice that has received anything. Run in the sql transaction that
+// changed the deposits.
+func matchInvoices(dbTx *sql.Tx, address, paymentID string) error {
+	query := `SELECT id, addr_id, paymentID, amount FROM invoices
+			WHERE addr_id = (SELECT id FROM addresses WHERE address = $1) AND paymentID = lower($2);`
+	args := []interface{}{address, paymentID}
+	if address == "" {
+		query = "SELECT id, addr_id, paymentID, amount FROM invoices WHERE received > 0;"
+		args = nil
+	}
+	rows, err := dbTx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	type match struct {
+		id, addrID int
+		paymentID  string
+		amount     float64
+	}
+	matches := []match{}
+	for rows.Next() {
+		m := match{}
+		if err = rows.Scan(&m.id, &m.addrID, &m.paymentID, &m.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range matches {
+		// payments mined after the invoice expired don't settle it
+		var received float64
+		err = dbTx.QueryRow(`SELECT COALESCE(sum(amount), 0) FROM transactions
+				WHERE addr_id = $1 AND trim(dest) = '' AND lower(paymentID) = $2
+				AND (block_time IS NULL OR block_time <= (SELECT expires FROM invoices WHERE id = $3));`,
+			m.addrID, strings.TrimSpace(m.paymentID), m.id).Scan(&received)
+		if err != nil {
+			return err
+		}
+		status := invoiceStatus(int64(math.Round(m.amount*divisor)), int64(math.Round(received*divisor)))
+		settled := status == invoicePaid || status == invoiceOverpaid
+		_, err = dbTx.Exec(`UPDATE invoices SET received = $2, status = $3,
+				paid = CASE WHEN $4 THEN COALESCE(paid, now()) END WHERE id = $1;`,
+			m.id, received, status, settled)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
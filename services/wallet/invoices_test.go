@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestInvoiceStatus(t *testing.T) {
+	for _, test := range []struct {
+		amount, received int64
+		want             string
+	}{
+		{500, 0, invoiceOpen},
+		{500, 499, invoiceUnderpaid},
+		{500, 500, invoicePaid},
+		{500, 600, invoiceOverpaid},
+	} {
+		if got := invoiceStatus(test.amount, test.received); got != test.want {
+			t.Errorf("invoiceStatus(%d, %d) = %s, want %s", test.amount, test.received, got, test.want)
+		}
+	}
+}
+
+func TestInvoiceMatchedOnScan(t *testing.T) {
+	needDB(t)
+	service, fake := testScanner(t)
+	defer dropScanner(t, service)
+	address := randomAddress()
+	var addrID int
+	err := walletDB.QueryRow("INSERT INTO addresses (address, backend) VALUES ($1, $2) RETURNING id;",
+		address, service.name).Scan(&addrID)
+	if err != nil {
+		t.Fatalf("address: %v", err)
+	}
+	defer func() {
+		for _, table := range []string{"invoices", "transactions", "addresses"} {
+			column := "addr_id"
+			if table == "addresses" {
+				column = "id"
+			}
+			if _, err := walletDB.Exec("DELETE FROM "+table+" WHERE "+column+" = $1;", addrID); err != nil {
+				t.Errorf("drop %s: %v", table, err)
+			}
+		}
+	}()
+	paymentID := randomHex(32)
+	_, err = walletDB.Exec(`INSERT INTO invoices (public_id, addr_id, integrated_address, paymentID, amount, expires)
+			VALUES ($1, $2, '', $3, 5, now() + interval '1 hour');`, randomHex(16), addrID, paymentID)
+	if err != nil {
+		t.Fatalf("invoice: %v", err)
+	}
+	status := func() (string, float64) {
+		var status string
+		var received float64
+		err := walletDB.QueryRow("SELECT status, received FROM invoices WHERE paymentID = $1;",
+			paymentID).Scan(&status, &received)
+		if err != nil {
+			t.Fatalf("invoice: %v", err)
+		}
+		return status, received
+	}
+
+	fake.mux.Lock()
+	fake.mine(Transaction{Amount: 500, PaymentID: paymentID,
+		Transfers: []Transfer{{Address: address, Amount: 500}}})
+	paidAt := int64(len(fake.blocks) - 1)
+	fake.mux.Unlock()
+	mineAndScan(t, service, fake, 1)
+	if got, received := status(); got != invoicePaid || received != 5 {
+		t.Errorf("invoice after the deposit = %s %v, want %s 5", got, received, invoicePaid)
+	}
+
+	// the deposit is reorged out
+	fake.mux.Lock()
+	fake.blocks[paidAt] = Block{BlockHash: randomHex(32)}
+	fake.mux.Unlock()
+	if err = service.checkReorg(); err != nil {
+		t.Fatalf("checkReorg: %v", err)
+	}
+	if got, received := status(); got != invoiceOpen || received != 0 {
+		t.Errorf("invoice after the reorg = %s %v, want %s 0", got, received, invoiceOpen)
+	}
+}
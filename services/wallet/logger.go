@@ -1,16 +1,14 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
-
-	"./turtlecoin-rpc-go/walletd"
 )
 
 var (
@@ -27,68 +25,89 @@ func init() {
 
 // TurtleService - daemon config
 type TurtleService struct {
+	name               string // of the backend, stored with its addresses
 	MaxPollingFailures int
 	PollingFailures    int
-	BindAddress        string
-	RPCPassword        string
-	RPCPort            int
-	PollingInterval    int // check if the daemon is alive every n seconds
-	ScanHeight         int64
-	LastBlock          int64 // last block scanned from getTransactions
+	PollingInterval    int   // check if the daemon is alive every n seconds
+	ScanHeight         int64 // next block to scan, persisted in scan_checkpoint
+	LastBlock          int64 // block count reported by walletd, guarded by mux
+	ReorgDepth         int64 // number of scanned blocks checked for reorgs
 	ScanInterval       int   // check for transactions every n seconds
 	SaveInterval       int   // save every n seconds
 	Timeout            int   // polling timeout
 	synced             bool
+	watchOnly          bool // tracking container for watch-only addresses
+	backend            WalletBackend
+	health             healthStatus
+	pinged             bool       // health was checked at least once
+	lastRestart        time.Time  // of walletd by the restart command
 	mux                sync.Mutex // only allow one goroutine to access a variable
+	saveMux            sync.Mutex // held while walletd writes the container file
+	scanMux            sync.Mutex // held while blocks are recorded, a backup holds it to pause them
 }
 
 // NewService - creates a turtleservice with the default options
-func NewService() *TurtleService {
+func NewService(name string, backend WalletBackend) *TurtleService {
 	service := &TurtleService{
+		name:               name,
 		MaxPollingFailures: 30,
 		PollingFailures:    0,
-		BindAddress:        "localhost",
 		SaveInterval:       60000,
 		ScanInterval:       5000,
 		LastBlock:          1,
+		ReorgDepth:         60,
 		Timeout:            5000,
 		PollingInterval:    10000,
-		RPCPort:            8070,
+		backend:            backend,
+		health:             healthStatus{Backend: name, State: healthSyncing, Since: time.Now()},
 	}
 	return service
 }
 
-// Start - starts the turtle-service
-func (service *TurtleService) Start() error {
-	service.loadConfig()
-	go service.pinger()
-	go service.saver()
-	service.scanner()
-	return nil
+// lastBlock - block count last reported by walletd
+func (service *TurtleService) lastBlock() int64 {
+	service.mux.Lock()
+	defer service.mux.Unlock()
+	return service.LastBlock
 }
 
-// loadConfig - loads data from data file
-func (service *TurtleService) loadConfig() {
-	conf, err := os.Open("./data/ha.data")
-	if err != nil {
-		panic(err)
-	}
-	bytes, err := ioutil.ReadAll(conf)
+// setLastBlock - records the block count reported by walletd
+func (service *TurtleService) setLastBlock(count int64) {
+	service.mux.Lock()
+	service.LastBlock = count
+	service.mux.Unlock()
+}
+
+// timeout - context bounded by the polling timeout
+func (service *TurtleService) timeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Millisecond*time.Duration(service.Timeout))
+}
+
+// Start - loads the scan checkpoint and starts the background loops of the service
+func (service *TurtleService) Start() error {
+	height, err := loadCheckpoint(service.name)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = json.Unmarshal(bytes, service)
-	if err != nil {
-		panic(err)
+	service.ScanHeight = height
+	runWorker(service.pinger)
+	runWorker(service.saver)
+	if !service.watchOnly {
+		runWorker(service.optimizer)
 	}
+	runWorker(service.delayedCollector)
+	runWorker(service.scanner)
+	runWorker(service.backfiller)
+	return nil
 }
 
 // saves the wallet every save interval if the wallet is synced
 func (service *TurtleService) saver() {
-	for ; ; time.Sleep(time.Millisecond * time.Duration(service.SaveInterval)) {
+	for ; !shuttingDown(); pause(time.Millisecond * time.Duration(service.SaveInterval)) {
 		if service.isSynced() {
-			fmt.Println("wallet is synced")
-			service.PollingFailures = 0
+			if err := service.Save(); err != nil {
+				fmt.Println("save", service.name+":", err)
+			}
 		} else {
 			fmt.Println("not saving: blockchain not synced")
 		}
@@ -96,131 +115,213 @@ func (service *TurtleService) saver() {
 }
 
 func (service *TurtleService) scanner() {
-	fmt.Println("scanner started")
-	for ; ; time.Sleep(time.Duration(service.ScanInterval) * time.Millisecond) {
-		result := map[string]interface{}{}
-		if service.ScanHeight < service.LastBlock {
-			fmt.Println(service.ScanHeight, " ", service.LastBlock)
-			response := walletd.GetTransactions(
-				service.RPCPassword,
-				service.BindAddress,
-				service.RPCPort,
-				int(service.ScanHeight),
-				int(service.LastBlock-service.ScanHeight),
-			)
-			fmt.Println(response)
-			if err := json.NewDecoder(response).Decode(&result); err != nil {
-				panic(err)
-			}
+	fmt.Println("scanner started for backend", service.name)
+	for ; !shuttingDown(); pause(time.Duration(service.ScanInterval) * time.Millisecond) {
+		service.scan()
+	}
+}
 
-			for _, block := range result["result"].(map[string]interface{})["items"].([]interface{}) {
-				for _, tx := range block.(map[string]interface{})["transactions"].([]interface{}) {
-					pID := tx.(map[string]interface{})["paymentId"].(string)
-					txID := tx.(map[string]interface{})["transactionHash"].(string)
-					transfer := tx.(map[string]interface{})["transfers"].([]interface{})
-					fmt.Println("Transaction:\n pId:", pID, "\nhash:", txID)
-					if amount := tx.(map[string]interface{})["amount"].(float64); amount > 0 {
-						for i := 0; i <= len(transfer)-2; i++ {
-							t := transfer[i].(map[string]interface{})
-							dest := t["address"].(string)
-							amount = t["amount"].(float64)
-							addTransaction(dest, "", txID, pID, amount)
-						}
-					} else {
-						fmt.Println(transfer)
-						chgAddr := transfer[len(transfer)-1].(map[string]interface{})
-						src := chgAddr["address"].(string)
-						for i := 1; i < len(transfer)-2; i++ {
-							t := transfer[i].(map[string]interface{})
-							dest := t["address"].(string)
-							amount = t["amount"].(float64)
-							addTransaction(src, dest, txID, pID, amount)
-						}
-					}
-				}
+// scan - records the blocks mined since the last round, backups wait for it
+func (service *TurtleService) scan() {
+	service.scanMux.Lock()
+	defer service.scanMux.Unlock()
+	if err := service.refreshPending(); err != nil {
+		fmt.Println("pending", service.name+":", err)
+	}
+	if err := service.checkReorg(); err != nil {
+		fmt.Println("reorg check", service.name+":", err)
+		return
+	}
+	lastBlock := service.lastBlock()
+	if service.ScanHeight >= lastBlock {
+		return
+	}
+	fmt.Println(service.name, service.ScanHeight, " ", lastBlock)
+	first, count := service.ScanHeight, lastBlock-service.ScanHeight
+	ctx, cancel := service.timeout()
+	blocks, err := service.backend.GetTransactions(ctx, first, count)
+	var hashes []string
+	if err == nil {
+		hashes, err = service.backend.GetBlockHashes(ctx, first, count)
+	}
+	cancel()
+	if err == nil {
+		err = verifyBlocks(blocks, hashes, first)
+	}
+	if err != nil {
+		fmt.Println("scanner", service.name+":", err)
+		return
+	}
+	if err = service.recordBlocks(first, blocks, hashes); err != nil {
+		fmt.Println("scanner", service.name+":", err)
+		return
+	}
+	service.ScanHeight = first + int64(len(hashes))
+}
+
+// txEntry - a row of the transactions table derived from a transaction
+type txEntry struct {
+	address string // container address the row belongs to
+	dest    string // recipient of a withdrawal, empty for deposits
+	amount  int64
+	fee     int64 // charged once per withdrawal, on its first entry
+	fusion  bool  // consolidates outputs of address, dest is address itself
+}
+
+// isFusion - fusion transactions move funds between outputs of the same
+// address, so they carry neither a fee nor a net amount
+func isFusion(tx *Transaction) bool {
+	return !tx.IsBase && tx.Fee == 0 && tx.Amount == 0 && len(tx.Transfers) > 0
+}
+
+// txEntries - splits a transaction into deposits to, or withdrawals from,
+// container addresses
+func txEntries(tx *Transaction) []txEntry {
+	entries := []txEntry{}
+	if isFusion(tx) {
+		address := tx.Transfers[0].Address
+		return append(entries, txEntry{address: address, dest: address, fusion: true})
+	}
+	if tx.Amount > 0 {
+		for _, t := range tx.Transfers {
+			if t.Amount > 0 && t.Type != transferTypeChange {
+				entries = append(entries, txEntry{address: t.Address, amount: t.Amount})
 			}
-			service.ScanHeight = service.LastBlock
+		}
+		return entries
+	}
+	var src string
+	for _, t := range tx.Transfers {
+		if t.Amount < 0 {
+			src = t.Address
+			break
+		}
+	}
+	if src == "" {
+		fmt.Println("no source address in transaction", tx.TransactionHash)
+		return entries
+	}
+	fee := tx.Fee
+	for _, t := range tx.Transfers {
+		if t.Amount > 0 && t.Type != transferTypeChange && t.Address != src {
+			entries = append(entries, txEntry{address: src, dest: t.Address, amount: t.Amount, fee: fee})
+			fee = 0
 		}
 	}
+	return entries
 }
 
-// checks if the wallet responds to rpc calls in the timeout period
-func (service *TurtleService) pinger() {
-	for ; ; time.Sleep(time.Duration(service.PollingInterval) * time.Millisecond) {
-		stat := make(chan int, 1)
-		go func() {
-			fmt.Println("wallet ping")
-			walletd.GetStatus(
-				service.RPCPassword,
-				service.BindAddress,
-				service.RPCPort,
-			)
-			stat <- 1
-		}()
-		select {
-		case <-stat:
-			service.updateData()
-			service.PollingFailures = 0
-			time.Sleep(time.Millisecond * time.Duration(service.PollingInterval))
-		case <-time.After(time.Millisecond * time.Duration(service.Timeout)):
-			service.PollingFailures++
-			if service.PollingFailures > service.MaxPollingFailures {
-				println("failed")
+// recordBlocks - adds the transfers of the scanned blocks to the database,
+// queues the webhook events of their deposits, matches them against
+// invoices, stores their hashes and moves
+// the checkpoint past them in one sql transaction, so a crash leaves either
+// all of them recorded or none
+func (service *TurtleService) recordBlocks(first int64, blocks []Block, hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	dbTx, err := walletDB.Begin()
+	if err != nil {
+		return err
+	}
+	// moving the checkpoint first locks it for the whole transaction, an
+	// address imported meanwhile is either seen by every insert below or
+	// backfilled up to the new checkpoint
+	if err = saveCheckpoint(dbTx, service.name, first+int64(len(hashes)), hashes[len(hashes)-1]); err != nil {
+		dbTx.Rollback()
+		return err
+	}
+	recorded := []*Transaction{}
+	for i := range blocks {
+		for j := range blocks[i].Transactions {
+			tx := &blocks[i].Transactions[j]
+			fmt.Println("Transaction:\n pId:", tx.PaymentID, "\nhash:", tx.TransactionHash)
+			for _, e := range txEntries(tx) {
+				if err = addTransaction(dbTx, e, tx); err != nil {
+					dbTx.Rollback()
+					return err
+				}
+				// events and invoices are updated with the deposit so a crash
+				// can't lose them
+				if e.dest != "" {
+					continue
+				}
+				if err = enqueueReceived(dbTx, e.address, tx, e.amount, service.lastBlock()); err != nil {
+					dbTx.Rollback()
+					return err
+				}
+				if tx.PaymentID == "" {
+					continue
+				}
+				if err = matchInvoices(dbTx, e.address, tx.PaymentID); err != nil {
+					dbTx.Rollback()
+					return err
+				}
 			}
+			recorded = append(recorded, tx)
 		}
 	}
+	if err = storeBlockHashes(dbTx, service.name, first, hashes, service.ReorgDepth); err != nil {
+		dbTx.Rollback()
+		return err
+	}
+	if err = dbTx.Commit(); err != nil {
+		return err
+	}
+	for _, tx := range recorded {
+		service.notifyTransaction(tx)
+	}
+	return nil
 }
 
-// check if the wallet is synced, saves the current block in a file
-func (service *TurtleService) isSynced() bool {
-	var jsonResponse map[string]interface{}
-	response := walletd.GetStatus(
-		service.RPCPassword,
-		service.BindAddress,
-		service.RPCPort,
-	)
-	json.NewDecoder(response).Decode(&jsonResponse)
-	blockCount := jsonResponse["result"].(map[string]interface{})["blockCount"].(float64)
-	knownBlockCount := jsonResponse["result"].(map[string]interface{})["knownBlockCount"].(float64)
-	service.LastBlock = int64(blockCount)
-	return int64(blockCount)+1 >= int64(knownBlockCount)
+// notifyTransaction - drops a recorded transaction from the pending
+// transactions
+func (service *TurtleService) notifyTransaction(tx *Transaction) {
+	reconcilePending(tx.TransactionHash)
 }
 
-// Save - saves the wallet
-func (service *TurtleService) Save() {
-	walletd.Save(
-		service.RPCPassword,
-		service.BindAddress,
-		service.RPCPort,
-	)
+// checks if the wallet responds to rpc calls in the timeout period and
+// updates its health
+func (service *TurtleService) pinger() {
+	for ; !shuttingDown(); pause(time.Duration(service.PollingInterval) * time.Millisecond) {
+		fmt.Println("wallet ping", service.name)
+		ctx, cancel := service.timeout()
+		status, err := service.backend.GetStatus(ctx)
+		cancel()
+		service.updateHealth(status, err)
+	}
 }
 
-// updates the values in ./data/ha.data
-func (service *TurtleService) updateData() {
-	f, err := os.Create("./data/ha.data")
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer f.Close()
-	err = json.NewEncoder(f).Encode(
-		map[string]interface{}{
-			"scanHeight": service.ScanHeight,
-			"lastBlock":  service.LastBlock,
-		},
-	)
+// check if the wallet is synced, records the current block count
+func (service *TurtleService) isSynced() bool {
+	ctx, cancel := service.timeout()
+	defer cancel()
+	status, err := service.backend.GetStatus(ctx)
 	if err != nil {
 		fmt.Println(err)
-		return
+		return false
 	}
+	service.setLastBlock(status.BlockCount)
+	return status.BlockCount+1 >= status.KnownBlockCount
 }
 
-// adds a transaction into the database
-func addTransaction(src, dest, hash, paymentID string, amount float64) {
-	_, err := walletDB.Exec(`INSERT INTO transactions (addr_id, dest, hash, paymentID, amount)
-			VALUES ((SELECT id FROM addresses WHERE address = $1),
-				$2, $3, $4, $5);`, src, dest, hash, paymentID, amount/100)
-	if err != nil {
-		fmt.Println(err)
-	}
+// Save - saves the wallet
+func (service *TurtleService) Save() error {
+	service.saveMux.Lock()
+	defer service.saveMux.Unlock()
+	ctx, cancel := service.timeout()
+	defer cancel()
+	return service.backend.Save(ctx)
+}
+
+// adds a transaction into the database, transfers of addresses that are not
+// stored are skipped
+func addTransaction(dbTx *sql.Tx, e txEntry, tx *Transaction) error {
+	_, err := dbTx.Exec(`INSERT INTO transactions (addr_id, dest, hash, paymentID, amount,
+				block_height, block_time, unlock_time, fusion, fee)
+			SELECT id, $2, $3, $4, $5, $6, to_timestamp($7), $8, $9, $10
+			FROM addresses WHERE address = $1;`,
+		e.address, e.dest, tx.TransactionHash, tx.PaymentID, float64(e.amount)/divisor,
+		tx.BlockIndex, tx.Timestamp, tx.UnlockTime, e.fusion, float64(e.fee)/divisor)
+	return err
 }
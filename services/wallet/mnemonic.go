@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	mnemonicListSize = 1626 // words of the CryptoNote english list
+	mnemonicPrefix   = 3    // leading letters that tell words apart
+	mnemonicLength   = 25   // 24 words of key and a checksum word
+	mnemonicQuizSize = 4    // words asked back before a seed counts as backed up
+)
+
+var (
+	mnemonicWords []string       // mnemonicEnglish, or the list loaded from MNEMONIC_WORDLIST
+	mnemonicIndex map[string]int // word to position in the list
+)
+
+// loadWordlist - reads a copy of the CryptoNote english word list, one word
+// per line in its original order, as in turtlecoin's src/mnemonics
+func loadWordlist(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return useWordlist(path, string(b))
+}
+
+// useWordlist - checks the words of list and decodes seeds with them
+func useWordlist(source, list string) error {
+	words := strings.Fields(strings.ToLower(list))
+	if len(words) != mnemonicListSize {
+		return fmt.Errorf("%s has %d words, the list has %d", source, len(words), mnemonicListSize)
+	}
+	index := map[string]int{}
+	prefixes := map[string]bool{}
+	for i, w := range words {
+		p := mnemonicWordPrefix(w)
+		if prefixes[p] {
+			return fmt.Errorf("%s: %q does not have a unique prefix", source, w)
+		}
+		prefixes[p] = true
+		index[w] = i
+	}
+	mnemonicWords, mnemonicIndex = words, index
+	return nil
+}
+
+// mnemonicWordPrefix - the leading letters of a word used by the checksum
+func mnemonicWordPrefix(w string) string {
+	if r := []rune(w); len(r) > mnemonicPrefix {
+		return string(r[:mnemonicPrefix])
+	}
+	return w
+}
+
+// mnemonicChecksum - position among words of the word repeated as checksum
+func mnemonicChecksum(words []string) int {
+	var prefixes strings.Builder
+	for _, w := range words {
+		prefixes.WriteString(mnemonicWordPrefix(w))
+	}
+	return int(crc32.ChecksumIEEE([]byte(prefixes.String())) % uint32(len(words)))
+}
+
+// decodeMnemonic - the hex spend secret key of a 25 word mnemonic seed.
+// Every 3 words encode 4 bytes of the key, the last word repeats one of
+// the others as a checksum.
+func decodeMnemonic(mnemonic string) (string, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) != mnemonicLength {
+		return "", fmt.Errorf("Mnemonic seed must be %d words", mnemonicLength)
+	}
+	indices := make([]uint32, mnemonicLength-1)
+	for i, w := range words[:mnemonicLength-1] {
+		index, ok := mnemonicIndex[w]
+		if !ok {
+			return "", fmt.Errorf("Unknown word %q in mnemonic seed", w)
+		}
+		indices[i] = uint32(index)
+	}
+	checksum := words[mnemonicChecksum(words[:mnemonicLength-1])]
+	if mnemonicWordPrefix(checksum) != mnemonicWordPrefix(words[mnemonicLength-1]) {
+		return "", errors.New("Mnemonic seed checksum does not match, check the words")
+	}
+	n := uint32(mnemonicListSize)
+	key := make([]byte, 4*len(indices)/3)
+	for i := 0; i < len(indices); i += 3 {
+		w1, w2, w3 := indices[i], indices[i+1], indices[i+2]
+		x := w1 + n*((n-w1+w2)%n) + n*n*((n-w2+w3)%n)
+		if x%n != w1 {
+			return "", errors.New("Invalid mnemonic seed")
+		}
+		binary.LittleEndian.PutUint32(key[4*i/3:], x)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// encodeMnemonic - the 25 word mnemonic seed of a hex spend secret key, the
+// reverse of decodeMnemonic
+func encodeMnemonic(spendKey string) (string, error) {
+	key, err := hex.DecodeString(spendKey)
+	if err != nil || len(key) != 32 {
+		return "", errors.New("Spend key must be 64 hex characters")
+	}
+	n := uint32(mnemonicListSize)
+	words := make([]string, 0, mnemonicLength)
+	for i := 0; i < len(key); i += 4 {
+		x := binary.LittleEndian.Uint32(key[i:])
+		w1 := x % n
+		w2 := (x/n + w1) % n
+		w3 := (x/n/n + w2) % n
+		words = append(words, mnemonicWords[w1], mnemonicWords[w2], mnemonicWords[w3])
+	}
+	words = append(words, words[mnemonicChecksum(words)])
+	return strings.Join(words, " "), nil
+}
+
+// checkMnemonicQuiz - whether words, given for the 1-based positions, are
+// those of the seed. At least mnemonicQuizSize different positions have to
+// be asked.
+func checkMnemonicQuiz(seed string, positions []int, words []string) error {
+	seedWords := strings.Fields(strings.ToLower(seed))
+	if len(seedWords) != mnemonicLength {
+		return errors.New("Wallet returned an invalid mnemonic seed")
+	}
+	if len(positions) != len(words) {
+		return errors.New("Answer every word of the quiz")
+	}
+	asked := map[int]bool{}
+	for i, p := range positions {
+		if p < 1 || p > mnemonicLength {
+			return fmt.Errorf("Word position must be 1 to %d", mnemonicLength)
+		}
+		asked[p] = true
+		if strings.ToLower(strings.TrimSpace(words[i])) != seedWords[p-1] {
+			return fmt.Errorf("Word %d is wrong, check your copy of the seed", p)
+		}
+	}
+	if len(asked) < mnemonicQuizSize {
+		return fmt.Errorf("Answer at least %d different words of the seed", mnemonicQuizSize)
+	}
+	return nil
+}
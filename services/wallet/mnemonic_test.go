@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// builtinWordlist - decodes seeds with the built-in list, configure loads it
+// only for the tests using the database
+func builtinWordlist(t *testing.T) {
+	if err := useWordlist("built-in word list", mnemonicEnglish); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMnemonicRoundTrip(t *testing.T) {
+	builtinWordlist(t)
+	keys := []string{strings.Repeat("0", 64), strings.Repeat("f", 64), strings.Repeat("01000000", 8)}
+	for i := 0; i < 20; i++ {
+		keys = append(keys, randomHex(32))
+	}
+	for _, key := range keys {
+		seed, err := encodeMnemonic(key)
+		if err != nil {
+			t.Fatalf("encodeMnemonic(%s): %v", key, err)
+		}
+		if words := strings.Fields(seed); len(words) != mnemonicLength {
+			t.Errorf("seed of %s has %d words", key, len(words))
+		}
+		if back, err := decodeMnemonic(strings.ToUpper(seed)); err != nil || back != key {
+			t.Errorf("decodeMnemonic(%s) = %s, %v, want %s", seed, back, err, key)
+		}
+	}
+
+	// every group of 3 words encodes 4 bytes of the key
+	for key, want := range map[string]string{
+		strings.Repeat("0", 64):       strings.TrimSpace(strings.Repeat("abbey ", mnemonicLength)),
+		strings.Repeat("01000000", 8): strings.TrimSpace(strings.Repeat("abducts ", mnemonicLength)),
+	} {
+		if seed, _ := encodeMnemonic(key); seed != want {
+			t.Errorf("encodeMnemonic(%s) = %s, want %s", key, seed, want)
+		}
+	}
+	if _, err := encodeMnemonic("abc"); err == nil {
+		t.Error("encodeMnemonic took a short key")
+	}
+}
+
+func TestDecodeMnemonicErrors(t *testing.T) {
+	builtinWordlist(t)
+	seed, err := encodeMnemonic(randomHex(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := strings.Fields(seed)
+	// a checksum word with another prefix than the one the checksum picks
+	checksum := "abbey"
+	if mnemonicWordPrefix(words[mnemonicLength-1]) == "abb" {
+		checksum = "zoom"
+	}
+	for name, mnemonic := range map[string]string{
+		"short":          strings.Join(words[:mnemonicLength-1], " "),
+		"unknown word":   "xyzzy " + strings.Join(words[1:], " "),
+		"wrong checksum": strings.Join(append(words[:mnemonicLength-1:mnemonicLength-1], checksum), " "),
+	} {
+		if key, err := decodeMnemonic(mnemonic); err == nil {
+			t.Errorf("decodeMnemonic took a %s seed as %s", name, key)
+		}
+	}
+}
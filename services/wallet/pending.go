@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+// pendingTTL - pending rows that left the mempool without being mined are
+// dropped after this long
+const pendingTTL = "30 minutes"
+
+// refreshPending - records container transactions waiting in the mempool
+func (service *TurtleService) refreshPending() error {
+	ctx, cancel := service.timeout()
+	defer cancel()
+	hashes, err := service.backend.GetUnconfirmedTransactionHashes(ctx, nil)
+	if err != nil {
+		return err
+	}
+	known, err := pendingHashes()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if known[hash] {
+			continue
+		}
+		tx, err := service.backend.GetTransaction(ctx, hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range txEntries(tx) {
+			addPending(e, tx)
+		}
+	}
+	_, err = walletDB.Exec("UPDATE pending_transactions SET seen = now() WHERE hash = ANY($1);",
+		pq.Array(hashes))
+	if err != nil {
+		return err
+	}
+	_, err = walletDB.Exec("DELETE FROM pending_transactions WHERE seen < now() - $1::interval;",
+		pendingTTL)
+	return err
+}
+
+// pendingHashes - hashes of the stored pending transactions
+func pendingHashes() (map[string]bool, error) {
+	rows, err := walletDB.Query("SELECT DISTINCT hash FROM pending_transactions;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := map[string]bool{}
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes[hash] = true
+	}
+	return hashes, rows.Err()
+}
+
+// addPending - stores a transfer seen in the mempool
+func addPending(e txEntry, tx *Transaction) {
+	_, err := walletDB.Exec(`INSERT INTO pending_transactions (hash, addr_id, dest, amount, paymentID, fusion)
+			VALUES ($1, (SELECT id FROM addresses WHERE address = $2), $3, $4, $5, $6)
+			ON CONFLICT DO NOTHING;`,
+		tx.TransactionHash, e.address, e.dest, float64(e.amount)/divisor, tx.PaymentID, e.fusion)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// reconcilePending - forgets a pending transaction once it has been mined
+func reconcilePending(hash string) {
+	if _, err := walletDB.Exec("DELETE FROM pending_transactions WHERE hash = $1;", hash); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// getPending - gets the transfers of an address that are not mined yet
+func getPending(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	rows, err := walletDB.Query(`SELECT dest, hash, amount, paymentID, fusion, `+contactName+` FROM pending_transactions t
+								 WHERE addr_id = (SELECT id FROM addresses WHERE address = $1) ORDER BY seen DESC;`,
+		p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var tmp string
+	txs := make([]transaction, 0)
+	for rows.Next() {
+		tx := transaction{State: txPending}
+		if err = rows.Scan(&tmp, &tx.Hash, &tx.Amount, &tx.PaymentID, &tx.Fusion, &tx.Contact); err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		if tmp[0] != ' ' {
+			tx.Destination = tmp
+		}
+		txs = append(txs, tx)
+	}
+	if err = rows.Err(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK",
+		Data: map[string]interface{}{"transactions": txs}})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// checkReorg - compares the stored hashes of the last ReorgDepth scanned
+// blocks with walletd, on a mismatch the rows recorded from the fork point
+// onwards are removed and ScanHeight is moved back so they get rescanned
+func (service *TurtleService) checkReorg() error {
+	// until walletd reported its blocks past the checkpoint, e.g. before the
+	// first ping or while it resyncs, there is nothing to compare with
+	service.mux.Lock()
+	lastBlock, pinged := service.LastBlock, service.pinged
+	service.mux.Unlock()
+	if !pinged || lastBlock < service.ScanHeight {
+		return nil
+	}
+	first := service.ScanHeight - service.ReorgDepth
+	if first < 0 {
+		first = 0
+	}
+	stored, err := loadBlockHashes(service.name, first, service.ScanHeight)
+	if err != nil || len(stored) == 0 {
+		return err
+	}
+	first = stored[0].height
+
+	ctx, cancel := service.timeout()
+	current, err := service.backend.GetBlockHashes(ctx, first, service.ScanHeight-first)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	// only the heights walletd returned are compared, a shorter answer is
+	// no fork
+	fork := int64(-1)
+	for i, block := range stored {
+		if i >= len(current) {
+			break
+		}
+		if current[i] != block.hash {
+			fork = block.height
+			break
+		}
+	}
+	if fork < 0 {
+		return nil
+	}
+	if err = rollbackFrom(service.name, fork); err != nil {
+		return err
+	}
+	depth := service.ScanHeight - fork
+	if fork == first && first > 0 {
+		fmt.Printf("reorg %s: fork point is at or below height %d, deeper than the %d blocks checked\n",
+			service.name, fork, service.ReorgDepth)
+	}
+	fmt.Printf("reorg %s: %d blocks replaced from height %d, rescanning\n", service.name, depth, fork)
+	service.ScanHeight = fork
+	return nil
+}
+
+// verifyBlocks - checks that the blocks returned by getTransactions belong
+// to the chain described by hashes, which starts at height first
+func verifyBlocks(blocks []Block, hashes []string, first int64) error {
+	for _, block := range blocks {
+		if len(block.Transactions) == 0 {
+			continue
+		}
+		i := block.Transactions[0].BlockIndex - first
+		if i < 0 || i >= int64(len(hashes)) || hashes[i] != block.BlockHash {
+			return errors.New("chain changed while scanning")
+		}
+	}
+	return nil
+}
+
+type blockHash struct {
+	height int64
+	hash   string
+}
+
+// loadBlockHashes - hashes stored by the scanner of backend for heights in
+// [from, to), ordered by height
+func loadBlockHashes(backend string, from, to int64) ([]blockHash, error) {
+	rows, err := walletDB.Query(`SELECT height, hash FROM block_hashes
+			WHERE backend = $1 AND height >= $2 AND height < $3 ORDER BY height;`, backend, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := []blockHash{}
+	for rows.Next() {
+		b := blockHash{}
+		if err = rows.Scan(&b.height, &b.hash); err != nil {
+			return nil, err
+		}
+		b.hash = strings.TrimSpace(b.hash)
+		hashes = append(hashes, b)
+	}
+	return hashes, rows.Err()
+}
+
+// storeBlockHashes - records the hashes of the blocks starting at height first
+// and forgets the ones too old to be checked for reorgs
+func storeBlockHashes(tx *sql.Tx, backend string, first int64, hashes []string, keep int64) error {
+	for i, hash := range hashes {
+		_, err := tx.Exec(`INSERT INTO block_hashes (backend, height, hash) VALUES ($1, $2, $3)
+				ON CONFLICT (backend, height) DO UPDATE SET hash = EXCLUDED.hash;`, backend, first+int64(i), hash)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("DELETE FROM block_hashes WHERE backend = $1 AND height < $2;",
+		backend, first+int64(len(hashes))-keep)
+	return err
+}
+
+// rollbackFrom - removes everything the scanner of backend recorded from
+// blocks at or above height and moves its checkpoint back to it, reversing
+// the webhook events already sent for the removed deposits and the invoices
+// they paid
+func rollbackFrom(backend string, height int64) error {
+	tx, err := walletDB.Begin()
+	if err != nil {
+		return err
+	}
+	if err = reverseDeliveries(tx, backend, height); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM transactions WHERE block_height >= $2
+			AND addr_id IN (SELECT id FROM addresses WHERE backend = $1);`, backend, height)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM block_hashes WHERE backend = $1 AND height >= $2;", backend, height)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = rewindCheckpoint(tx, backend, height); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// the scanner records the rewound blocks for imported addresses as well
+	_, err = tx.Exec(`UPDATE backfills SET height = LEAST(height, $2), until_height = LEAST(until_height, $2)
+			WHERE backend = $1;`, backend, height)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// invoices paid by the removed deposits are open again
+	if err = matchInvoices(tx, "", ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVerifyBlocks(t *testing.T) {
+	hashes := []string{"a", "b", "c"}
+	block := func(hash string, height int64) Block {
+		return Block{BlockHash: hash, Transactions: []Transaction{{BlockIndex: height}}}
+	}
+	if err := verifyBlocks([]Block{block("b", 11), {BlockHash: "x"}, block("c", 12)}, hashes, 10); err != nil {
+		t.Errorf("matching blocks: %v", err)
+	}
+	for _, blocks := range [][]Block{
+		{block("x", 11)},
+		{block("a", 9)},
+		{block("c", 13)},
+	} {
+		if err := verifyBlocks(blocks, hashes, 10); err == nil {
+			t.Errorf("verifyBlocks took block %s at %d", blocks[0].BlockHash, blocks[0].Transactions[0].BlockIndex)
+		}
+	}
+}
+
+func TestCheckReorgBeforePing(t *testing.T) {
+	// walletd was not asked for its height yet, the check must not touch
+	// the database or compare anything
+	service := NewService("test", newFakeBackend())
+	service.ScanHeight = 100
+	if err := service.checkReorg(); err != nil {
+		t.Errorf("checkReorg before the first ping: %v", err)
+	}
+}
+
+// testScanner - a scanner of its own fake backend, remove its rows with
+// dropScanner
+func testScanner(t *testing.T) (*TurtleService, *fakeBackend) {
+	fake := newFakeBackend()
+	service := NewService("test-"+randomHex(8), fake)
+	height, err := loadCheckpoint(service.name)
+	if err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	service.ScanHeight = height
+	return service, fake
+}
+
+func dropScanner(t *testing.T, service *TurtleService) {
+	for _, table := range []string{"block_hashes", "scan_checkpoint"} {
+		if _, err := walletDB.Exec("DELETE FROM "+table+" WHERE backend = $1;", service.name); err != nil {
+			t.Errorf("drop %s: %v", table, err)
+		}
+	}
+}
+
+// mineAndScan - mines count empty blocks, reports them to service and scans
+func mineAndScan(t *testing.T, service *TurtleService, fake *fakeBackend, count int) {
+	fake.mux.Lock()
+	for i := 0; i < count; i++ {
+		fake.mine()
+	}
+	fake.mux.Unlock()
+	status, err := fake.GetStatus(context.Background())
+	service.updateHealth(status, err)
+	service.scan()
+	if service.ScanHeight != status.BlockCount {
+		t.Fatalf("scanned to %d, want %d", service.ScanHeight, status.BlockCount)
+	}
+}
+
+func storedHashes(t *testing.T, service *TurtleService) int {
+	var count int
+	err := walletDB.QueryRow("SELECT count(*) FROM block_hashes WHERE backend = $1;", service.name).Scan(&count)
+	if err != nil {
+		t.Fatalf("block hashes: %v", err)
+	}
+	return count
+}
+
+func TestCheckReorgBehind(t *testing.T) {
+	needDB(t)
+	service, fake := testScanner(t)
+	defer dropScanner(t, service)
+	mineAndScan(t, service, fake, 5)
+	scanned := service.ScanHeight
+
+	// walletd resyncs and reports fewer blocks than were scanned
+	fake.mux.Lock()
+	fake.blocks = fake.blocks[:3]
+	fake.mux.Unlock()
+	status, err := fake.GetStatus(context.Background())
+	service.updateHealth(status, err)
+	if err = service.checkReorg(); err != nil {
+		t.Fatalf("checkReorg: %v", err)
+	}
+	// and returns fewer hashes than the block count it reported
+	service.setLastBlock(scanned)
+	if err = service.checkReorg(); err != nil {
+		t.Fatalf("checkReorg: %v", err)
+	}
+	if service.ScanHeight != scanned {
+		t.Errorf("ScanHeight = %d, want %d", service.ScanHeight, scanned)
+	}
+	if got := storedHashes(t, service); got != int(scanned) {
+		t.Errorf("%d block hashes left, want %d", got, scanned)
+	}
+}
+
+func TestReorgRecovery(t *testing.T) {
+	needDB(t)
+	service, fake := testScanner(t)
+	defer dropScanner(t, service)
+	mineAndScan(t, service, fake, 5)
+
+	// blocks from height 3 on are replaced
+	const fork = 3
+	fake.mux.Lock()
+	for i := fork; i < len(fake.blocks); i++ {
+		fake.blocks[i].BlockHash = randomHex(32)
+	}
+	fake.mux.Unlock()
+	if err := service.checkReorg(); err != nil {
+		t.Fatalf("checkReorg: %v", err)
+	}
+	if service.ScanHeight != fork {
+		t.Errorf("ScanHeight = %d, want %d", service.ScanHeight, fork)
+	}
+	if got := storedHashes(t, service); got != fork {
+		t.Errorf("%d block hashes left, want %d", got, fork)
+	}
+	height, err := loadCheckpoint(service.name)
+	if err != nil || height != fork {
+		t.Errorf("checkpoint = %d, %v, want %d", height, err, fork)
+	}
+
+	// the rescan continues from the fork point onto the new chain
+	mineAndScan(t, service, fake, 1)
+	if got := storedHashes(t, service); got != int(service.ScanHeight) {
+		t.Errorf("%d block hashes after the rescan, want %d", got, service.ScanHeight)
+	}
+	if err = service.checkReorg(); err != nil || service.ScanHeight != int64(len(fake.blocks)) {
+		t.Errorf("checkReorg after the rescan moved ScanHeight to %d, %v", service.ScanHeight, err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const restoreUsage = `usage: wallet verify <archive>
+       wallet restore <archive>`
+
+// runCommand - runs a maintenance command given on the command line instead
+// of the service, returns the exit code
+func runCommand(args []string) int {
+	if len(args) != 2 || (args[0] != "verify" && args[0] != "restore") {
+		fmt.Println(restoreUsage)
+		return 2
+	}
+	if backupKey == nil {
+		fmt.Println("Set the BACKUP_KEY env variable")
+		return 2
+	}
+	var err error
+	if args[0] == "verify" {
+		err = verifyCommand(args[1])
+	} else {
+		err = restoreCommand(args[1])
+	}
+	if err != nil {
+		fmt.Println(args[0]+":", err)
+		return 1
+	}
+	return 0
+}
+
+// verifyCommand - checks the integrity of an archive and lists its contents
+func verifyCommand(path string) error {
+	manifest, err := verifyBackup(path, "")
+	if err != nil {
+		return err
+	}
+	fmt.Println(path, "is intact, created", manifest.Created.Format(time.RFC3339))
+	for _, f := range manifest.Files {
+		if f.Backend != "" {
+			fmt.Printf("  %s: container of backend %s at height %d, %d bytes\n", f.Name, f.Backend, f.Height, f.Size)
+		} else {
+			fmt.Printf("  %s: database dump, %d bytes\n", f.Name, f.Size)
+		}
+	}
+	return nil
+}
+
+// restoreCommand - checks an archive, restores tx_history from its dump and
+// puts its containers where the backends expect them. Run it with
+// turtle-service and the wallet service stopped, then start turtle-service
+// on the restored containers.
+func restoreCommand(path string) error {
+	dir, err := ioutil.TempDir("", "shellnet-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	manifest, err := verifyBackup(path, dir)
+	if err != nil {
+		return err
+	}
+	fmt.Println(path, "is intact, created", manifest.Created.Format(time.RFC3339))
+
+	// every container needs a destination before anything is changed
+	for _, f := range manifest.Files {
+		if f.Backend == "" {
+			continue
+		}
+		if _, ok := containerFiles[f.Backend]; !ok {
+			return fmt.Errorf("no container file for backend %s, set CONTAINER_FILE_%s",
+				f.Backend, strings.ToUpper(f.Backend))
+		}
+	}
+
+	if err = restoreDatabase(filepath.Join(dir, dumpEntry)); err != nil {
+		return err
+	}
+	fmt.Println("database restored")
+	for _, f := range manifest.Files {
+		if f.Backend == "" {
+			continue
+		}
+		dest := containerFiles[f.Backend]
+		if err = restoreContainer(filepath.Join(dir, filepath.FromSlash(f.Name)), dest); err != nil {
+			return err
+		}
+		fmt.Println("container of backend", f.Backend, "restored to", dest)
+	}
+	if err = resetVolatileState(); err != nil {
+		return err
+	}
+	rows, err := walletDB.Query("SELECT backend, height FROM scan_checkpoint ORDER BY backend;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var backend string
+		var height int64
+		if err = rows.Scan(&backend, &height); err != nil {
+			return err
+		}
+		fmt.Println("the scanner of backend", backend, "resumes at height", height)
+	}
+	return rows.Err()
+}
+
+// restoreDatabase - replaces the contents of tx_history with a dump, in a
+// single transaction so a failed restore changes nothing
+func restoreDatabase(dump string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dumpTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, pgRestore, "--clean", "--if-exists", "--single-transaction",
+		"--no-owner", "--host=localhost", "--username="+dbUser, "--dbname=tx_history", dump)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+dbPwd)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("pg_restore: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// restoreContainer - moves a restored container to dest, a file already
+// there is kept next to it
+func restoreContainer(src, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		aside := dest + ".pre-restore-" + time.Now().UTC().Format(backupTimeFormat)
+		if err = os.Rename(dest, aside); err != nil {
+			return err
+		}
+		fmt.Println("existing container moved to", aside)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// resetVolatileState - forgets what only lived in the mempool or in the
+// lost walletd at the time of the backup: pending transfers are picked up
+// again from the mempool and delayed transactions have to be prepared again
+func resetVolatileState() error {
+	if _, err := walletDB.Exec("DELETE FROM pending_transactions;"); err != nil {
+		return err
+	}
+	_, err := walletDB.Exec("UPDATE delayed_transactions SET status = $1 WHERE status = 'created';",
+		delayedDeleted)
+	return err
+}
@@ -5,4 +5,4 @@ HOST_URI='http://localhost' \
 HOST_PORT=':8082' \
 RPC_PWD=  \
 RPC_PORT='8070' \
-go run wallet.go init.go logger.go utils.go
+go run wallet.go init.go logger.go utils.go backend.go walletd.go fake.go integrated.go batch.go reorg.go pending.go idempotency.go webhooks.go invoices.go fusion.go delayed.go contacts.go export.go history.go checkpoint.go shards.go health.go shutdown.go backup.go restore.go import.go mnemonic.go wordlist.go watch.go spending.go withdrawals.go keccak.go address.go
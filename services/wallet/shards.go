@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultBackend    = "default" // backend of addresses created before sharding
+	backendNameFormat = "^[a-z0-9_]{1,32}$"
+)
+
+// policies for assigning new addresses to backends
+const (
+	policyLeastLoaded = "least-loaded"
+	policyRoundRobin  = "round-robin"
+)
+
+var (
+	services    = map[string]*TurtleService{} // by backend name
+	serviceList = []*TurtleService{}          // in configuration order
+	shardPolicy = policyLeastLoaded
+	roundRobin  int
+	roundMux    sync.Mutex
+)
+
+var errUnknownAddress = errors.New("Unknown Address")
+
+// backendConfig - a walletd backend from RPC_BACKENDS, port 0 means RPC_PORT
+type backendConfig struct {
+	name string
+	host string
+	port int
+}
+
+// parseBackends - reads a comma separated list of name=host[:port]
+func parseBackends(s string) ([]backendConfig, error) {
+	configs := []backendConfig{}
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("backend " + entry + ": expected name=host[:port]")
+		}
+		c := backendConfig{name: parts[0], host: parts[1]}
+		if matched, _ := regexp.MatchString(backendNameFormat, c.name); !matched {
+			return nil, errors.New("backend " + c.name + ": names are lowercase letters, digits and _")
+		}
+		if seen[c.name] {
+			return nil, errors.New("backend " + c.name + " is configured twice")
+		}
+		seen[c.name] = true
+		if i := strings.LastIndex(c.host, ":"); i >= 0 {
+			port, err := strconv.Atoi(c.host[i+1:])
+			if err != nil || port <= 0 {
+				return nil, errors.New("backend " + c.name + ": bad port")
+			}
+			c.host, c.port = c.host[:i], port
+		}
+		if c.host == "" {
+			return nil, errors.New("backend " + c.name + ": missing host")
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// addService - registers the service of a backend
+func addService(service *TurtleService) {
+	services[service.name] = service
+	serviceList = append(serviceList, service)
+}
+
+// serviceFor - the service of the backend holding address
+func serviceFor(address string) (*TurtleService, error) {
+	var name string
+	err := walletDB.QueryRow("SELECT backend FROM addresses WHERE address = $1;", address).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil, errUnknownAddress
+	}
+	if err != nil {
+		return nil, err
+	}
+	service, ok := services[strings.TrimSpace(name)]
+	if !ok {
+		return nil, errors.New("Backend " + name + " is not configured")
+	}
+	return service, nil
+}
+
+// backendFor - the walletd backend holding address
+func backendFor(address string) (WalletBackend, error) {
+	service, err := serviceFor(address)
+	if err != nil {
+		return nil, err
+	}
+	return service.backend, nil
+}
+
+// assignOrder - the services to try, in order, for a new address. Healthier
+// backends come first, then shardPolicy decides. Tracking containers only
+// take watch-only addresses and are left out.
+func assignOrder() ([]*TurtleService, error) {
+	spending := []*TurtleService{}
+	for _, service := range serviceList {
+		if !service.watchOnly {
+			spending = append(spending, service)
+		}
+	}
+	order := make([]*TurtleService, len(spending))
+	switch shardPolicy {
+	case policyRoundRobin:
+		roundMux.Lock()
+		start := roundRobin % len(spending)
+		roundRobin++
+		roundMux.Unlock()
+		for i := range spending {
+			order[i] = spending[(start+i)%len(spending)]
+		}
+	default:
+		loads, err := backendLoads()
+		if err != nil {
+			return nil, err
+		}
+		copy(order, spending)
+		sort.SliceStable(order, func(i, j int) bool {
+			return loads[order[i].name] < loads[order[j].name]
+		})
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return healthRank[order[i].healthState().State] < healthRank[order[j].healthState().State]
+	})
+	return order, nil
+}
+
+// backendLoads - number of addresses held by each backend
+func backendLoads() (map[string]int, error) {
+	rows, err := walletDB.Query("SELECT backend, count(*) FROM addresses GROUP BY backend;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	loads := map[string]int{}
+	for rows.Next() {
+		var name string
+		var n int
+		if err = rows.Scan(&name, &n); err != nil {
+			return nil, err
+		}
+		loads[strings.TrimSpace(name)] = n
+	}
+	return loads, rows.Err()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout - how long in-flight requests and background loops get to
+// finish once a shutdown starts
+const shutdownTimeout = time.Minute
+
+var (
+	stopping = make(chan struct{}) // closed when the background loops have to stop
+	workers  sync.WaitGroup        // background loops still running
+)
+
+// shuttingDown - whether the background loops have to stop
+func shuttingDown() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// pause - sleeps for d, or less if a shutdown starts
+func pause(d time.Duration) {
+	select {
+	case <-stopping:
+	case <-time.After(d):
+	}
+}
+
+// runWorker - runs a background loop that shutdown waits for
+func runWorker(loop func()) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		loop()
+	}()
+}
+
+// shutdownOnSignal - on SIGINT or SIGTERM stops accepting requests and waits
+// for the ones in flight, lets the background loops finish their current
+// round and saves every container. The returned channel is closed once it is
+// safe to exit.
+func shutdownOnSignal(srv *http.Server) <-chan struct{} {
+	done := make(chan struct{})
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		fmt.Println("shutdown:", <-c)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Println("shutdown:", err)
+		}
+
+		close(stopping)
+		stopped := make(chan struct{})
+		go func() {
+			workers.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			fmt.Println("shutdown: background loops did not stop in time")
+		}
+
+		for _, service := range serviceList {
+			// the checkpoint is committed with every scanned batch, so
+			// there is nothing left to write once the scanner stopped
+			fmt.Println("shutdown:", service.name, "scanned up to height", service.ScanHeight)
+			if err := service.Save(); err != nil {
+				fmt.Println("shutdown: save", service.name+":", err)
+				continue
+			}
+			fmt.Println("shutdown:", service.name, "container saved")
+		}
+		walletDB.Close()
+		close(done)
+	}()
+	return done
+}
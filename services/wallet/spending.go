@@ -0,0 +1,583 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxAllowedDestinations = 100 // per address
+	maxAllowedLabelLen     = 64
+	spendEventsShown       = 20
+	spendWindow            = "7 days" // longest window a limit looks back on
+	spendsInterval         = time.Hour
+)
+
+// spending events, recorded with the address they belong to
+const (
+	spendEventTxLimit     = "tx_limit"
+	spendEventDailyLimit  = "daily_limit"
+	spendEventWeeklyLimit = "weekly_limit"
+	spendEventAllowlist   = "allowlist"
+	spendEventUpdated     = "policy_updated"
+	spendEventRequested   = "change_requested"
+	spendEventApplied     = "change_applied"
+	spendEventCancelled   = "change_cancelled"
+	spendEventAllowAdd    = "allowlist_add"
+	spendEventAllowRemove = "allowlist_remove"
+)
+
+// spendCooldown - delay before a change loosening a policy takes effect,
+// so a stolen session can't lift the limits and drain the account at once
+var spendCooldown = 24 * time.Hour
+
+// spendPolicy - the limits of an address in coin units, 0 for no limit
+type spendPolicy struct {
+	DailyLimit    float64 // over the last 24 hours
+	WeeklyLimit   float64 // over the last 7 days
+	TxLimit       float64 // per send, a batch counts as one
+	AllowlistOnly bool    // only send to allowed destinations
+	Effective     string  `json:",omitempty"` // when a pending change applies
+}
+
+// allowedDestination - an entry of the destination allowlist
+type allowedDestination struct {
+	ID        int
+	Address   string
+	Label     string
+	Effective string
+	Active    bool // past its cooldown
+}
+
+// spendEvent - a limit hit or a change of the policy
+type spendEvent struct {
+	Event   string
+	Detail  string
+	Created string
+}
+
+// spendError - a send refused by the policy
+type spendError struct {
+	event   string
+	message string
+}
+
+func (e *spendError) Error() string {
+	return e.message
+}
+
+// looserLimit - whether limit next allows more than limit cur
+func looserLimit(cur, next float64) bool {
+	return cur != 0 && (next == 0 || next > cur)
+}
+
+// tighterLimit - the stricter of two limits
+func tighterLimit(cur, next float64) float64 {
+	if cur == 0 || (next != 0 && next < cur) {
+		return next
+	}
+	return cur
+}
+
+// looser - whether p allows anything cur doesn't
+func (p *spendPolicy) looser(cur *spendPolicy) bool {
+	return looserLimit(cur.DailyLimit, p.DailyLimit) || looserLimit(cur.WeeklyLimit, p.WeeklyLimit) ||
+		looserLimit(cur.TxLimit, p.TxLimit) || (cur.AllowlistOnly && !p.AllowlistOnly)
+}
+
+// tighter - the policy applying the stricter part of p and cur each
+func (p *spendPolicy) tighter(cur *spendPolicy) *spendPolicy {
+	return &spendPolicy{
+		DailyLimit:    tighterLimit(cur.DailyLimit, p.DailyLimit),
+		WeeklyLimit:   tighterLimit(cur.WeeklyLimit, p.WeeklyLimit),
+		TxLimit:       tighterLimit(cur.TxLimit, p.TxLimit),
+		AllowlistOnly: cur.AllowlistOnly || p.AllowlistOnly,
+	}
+}
+
+// String - the policy as recorded in the events
+func (p *spendPolicy) String() string {
+	limit := func(v float64) string {
+		if v == 0 {
+			return "none"
+		}
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+	return fmt.Sprintf("daily %s, weekly %s, per send %s, allowlist only %t",
+		limit(p.DailyLimit), limit(p.WeeklyLimit), limit(p.TxLimit), p.AllowlistOnly)
+}
+
+// execer - a *sql.DB or *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordSpendEvent - adds an event to the history of addrID
+func recordSpendEvent(q execer, addrID int, event, detail string) {
+	_, err := q.Exec("INSERT INTO spend_events (addr_id, event, detail) VALUES ($1, $2, $3);",
+		addrID, event, detail)
+	if err != nil {
+		fmt.Println("spend event", addrID, event, err)
+	}
+}
+
+// currentPolicy - the policy of addrID after applying a pending change
+// whose cooldown is over, no limits if none was set
+func currentPolicy(tx *sql.Tx, addrID int) (*spendPolicy, error) {
+	p := &spendPolicy{}
+	err := tx.QueryRow(`DELETE FROM spend_policy_changes WHERE addr_id = $1 AND effective <= now()
+			RETURNING daily_limit, weekly_limit, tx_limit, allowlist_only;`, addrID).Scan(
+		&p.DailyLimit, &p.WeeklyLimit, &p.TxLimit, &p.AllowlistOnly)
+	if err == nil {
+		if err = storePolicy(tx, addrID, p); err != nil {
+			return nil, err
+		}
+		recordSpendEvent(tx, addrID, spendEventApplied, p.String())
+		return p, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	err = tx.QueryRow(`SELECT daily_limit, weekly_limit, tx_limit, allowlist_only
+			FROM spend_policies WHERE addr_id = $1;`, addrID).Scan(
+		&p.DailyLimit, &p.WeeklyLimit, &p.TxLimit, &p.AllowlistOnly)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return p, err
+}
+
+// storePolicy - sets the policy in effect for addrID
+func storePolicy(tx *sql.Tx, addrID int, p *spendPolicy) error {
+	_, err := tx.Exec(`INSERT INTO spend_policies (addr_id, daily_limit, weekly_limit, tx_limit, allowlist_only)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT (addr_id) DO UPDATE SET daily_limit = EXCLUDED.daily_limit,
+			weekly_limit = EXCLUDED.weekly_limit, tx_limit = EXCLUDED.tx_limit,
+			allowlist_only = EXCLUDED.allowlist_only, updated = now();`,
+		addrID, p.DailyLimit, p.WeeklyLimit, p.TxLimit, p.AllowlistOnly)
+	return err
+}
+
+// lockAddress - starts a transaction holding the row of a spending address,
+// which serializes the sends and policy changes of that address
+func lockAddress(address string) (*sql.Tx, int, error) {
+	addrID, err := ownerID(address)
+	if err != nil {
+		return nil, 0, err
+	}
+	tx, err := walletDB.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err = tx.Exec("SELECT id FROM addresses WHERE id = $1 FOR UPDATE;", addrID); err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+	return tx, addrID, nil
+}
+
+// reserveSpend - checks transfers from address against its policy and,
+// with a reference, counts them as spent until settleSpend says otherwise.
+// Refused sends are recorded as events.
+func reserveSpend(address, reference string, transfers []Destination) error {
+	tx, addrID, err := lockAddress(address)
+	if err != nil {
+		return err
+	}
+	policy, err := currentPolicy(tx, addrID)
+	if err == nil {
+		err = checkSpend(tx, addrID, policy, transfers)
+	}
+	if serr, ok := err.(*spendError); ok {
+		recordSpendEvent(tx, addrID, serr.event, serr.message)
+		fmt.Println("spending policy", address+":", serr.message)
+		if cerr := tx.Commit(); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	if err == nil && reference != "" {
+		var total int64
+		for _, d := range transfers {
+			total += d.Amount
+		}
+		_, err = tx.Exec("INSERT INTO spends (addr_id, reference, amount) VALUES ($1, $2, $3);",
+			addrID, strings.ToLower(reference), total)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkSpend - the reason policy refuses transfers, if any
+func checkSpend(tx *sql.Tx, addrID int, policy *spendPolicy, transfers []Destination) error {
+	var total int64
+	for _, d := range transfers {
+		total += d.Amount
+	}
+	coins := func(amount int64) string {
+		return strconv.FormatFloat(float64(amount)/divisor, 'f', 2, 64)
+	}
+	atomic := func(v float64) int64 {
+		return int64(math.Round(v * divisor))
+	}
+	if policy.TxLimit != 0 && total > atomic(policy.TxLimit) {
+		return &spendError{spendEventTxLimit, fmt.Sprintf("Sending %s is over the limit of %s per send",
+			coins(total), coins(atomic(policy.TxLimit)))}
+	}
+	if policy.AllowlistOnly {
+		for _, d := range transfers {
+			var allowed bool
+			err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM allowed_destinations
+					WHERE addr_id = $1 AND address = $2 AND effective <= now());`, addrID, d.Address).Scan(&allowed)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				return &spendError{spendEventAllowlist, "Destination " + d.Address + " is not on the allowlist"}
+			}
+		}
+	}
+	windows := []struct {
+		limit    float64
+		interval string
+		event    string
+		name     string
+	}{
+		{policy.DailyLimit, "1 day", spendEventDailyLimit, "daily"},
+		{policy.WeeklyLimit, "7 days", spendEventWeeklyLimit, "weekly"},
+	}
+	for _, w := range windows {
+		if w.limit == 0 {
+			continue
+		}
+		var spent int64
+		err := tx.QueryRow(`SELECT COALESCE(sum(amount), 0)::bigint FROM spends
+				WHERE addr_id = $1 AND created > now() - $2::interval;`, addrID, w.interval).Scan(&spent)
+		if err != nil {
+			return err
+		}
+		if spent+total > atomic(w.limit) {
+			left := atomic(w.limit) - spent
+			if left < 0 {
+				left = 0
+			}
+			return &spendError{w.event, fmt.Sprintf("Sending %s is over the %s limit of %s, %s left",
+				coins(total), w.name, coins(atomic(w.limit)), coins(left))}
+		}
+	}
+	return nil
+}
+
+// settleSpend - corrects the amount reserved under reference to what was
+// actually sent
+func settleSpend(reference string, sent int64) {
+	var err error
+	if sent == 0 {
+		_, err = walletDB.Exec("DELETE FROM spends WHERE reference = $1;", strings.ToLower(reference))
+	} else {
+		_, err = walletDB.Exec("UPDATE spends SET amount = $2 WHERE reference = $1;",
+			strings.ToLower(reference), sent)
+	}
+	if err != nil {
+		fmt.Println("spend", reference, err)
+	}
+}
+
+// spendsCollector - deletes the spends no limit looks back on anymore
+func spendsCollector() {
+	for ; !shuttingDown(); pause(spendsInterval) {
+		_, err := walletDB.Exec("DELETE FROM spends WHERE created < now() - $1::interval;", spendWindow)
+		if err != nil {
+			fmt.Println("spends:", err)
+		}
+	}
+}
+
+// parseLimit - a limit form value in coin units, empty for no limit
+func parseLimit(v string) (float64, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, nil
+	}
+	if matched, _ := regexp.MatchString(amountFormat, v); !matched {
+		return 0, fmt.Errorf("Incorrect limit %q", v)
+	}
+	amount, _ := parseAmount(v)
+	if amount <= 0 {
+		return 0, fmt.Errorf("Incorrect limit %q", v)
+	}
+	return float64(amount) / divisor, nil
+}
+
+// getSpendPolicy - the policy of an address with its pending change,
+// allowlist, amounts spent and latest events
+func getSpendPolicy(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	tx, addrID, err := lockAddress(p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	policy, err := currentPolicy(tx, addrID)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	data := map[string]interface{}{"policy": policy, "cooldown": spendCooldown.String()}
+
+	pending := &spendPolicy{}
+	err = tx.QueryRow(`SELECT daily_limit, weekly_limit, tx_limit, allowlist_only,
+				to_char(effective, 'YYYY-MM-DD HH24:MI:SS')
+			FROM spend_policy_changes WHERE addr_id = $1;`, addrID).Scan(
+		&pending.DailyLimit, &pending.WeeklyLimit, &pending.TxLimit, &pending.AllowlistOnly, &pending.Effective)
+	if err == nil {
+		data["pending"] = pending
+	} else if err != sql.ErrNoRows {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+
+	var day, week int64
+	err = tx.QueryRow(`SELECT COALESCE(sum(amount) FILTER (WHERE created > now() - interval '1 day'), 0)::bigint,
+				COALESCE(sum(amount), 0)::bigint
+			FROM spends WHERE addr_id = $1 AND created > now() - interval '7 days';`, addrID).Scan(&day, &week)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	data["spentDay"], data["spentWeek"] = float64(day)/divisor, float64(week)/divisor
+
+	rows, err := tx.Query(`SELECT id, address, label, to_char(effective, 'YYYY-MM-DD HH24:MI:SS'),
+				effective <= now()
+			FROM allowed_destinations WHERE addr_id = $1 ORDER BY lower(label), address;`, addrID)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	allowlist := make([]allowedDestination, 0)
+	for rows.Next() {
+		d := allowedDestination{}
+		if err = rows.Scan(&d.ID, &d.Address, &d.Label, &d.Effective, &d.Active); err != nil {
+			break
+		}
+		allowlist = append(allowlist, d)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	data["allowlist"] = allowlist
+
+	rows, err = tx.Query(`SELECT event, detail, to_char(created, 'YYYY-MM-DD HH24:MI:SS')
+			FROM spend_events WHERE addr_id = $1 ORDER BY id DESC LIMIT $2;`, addrID, spendEventsShown)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	events := make([]spendEvent, 0)
+	for rows.Next() {
+		e := spendEvent{}
+		if err = rows.Scan(&e.Event, &e.Detail, &e.Created); err != nil {
+			break
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	data["events"] = events
+	// keeps a pending change applied above
+	if err = tx.Commit(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: data})
+}
+
+// saveSpendPolicy - sets the limits of an address. Whatever makes them
+// stricter applies at once, a change allowing more waits for spendCooldown
+// and replaces the change pending before.
+func saveSpendPolicy(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	next := &spendPolicy{AllowlistOnly: req.FormValue("allowlist_only") != ""}
+	var err error
+	for _, f := range []struct {
+		name  string
+		limit *float64
+	}{{"daily_limit", &next.DailyLimit}, {"weekly_limit", &next.WeeklyLimit}, {"tx_limit", &next.TxLimit}} {
+		if *f.limit, err = parseLimit(req.FormValue(f.name)); err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+	}
+	tx, addrID, err := lockAddress(req.FormValue("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	cur, err := currentPolicy(tx, addrID)
+	if err == nil {
+		err = storePolicy(tx, addrID, next.tighter(cur))
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM spend_policy_changes WHERE addr_id = $1;", addrID)
+	}
+	if err != nil {
+		tx.Rollback()
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	data := map[string]interface{}{}
+	if next.looser(cur) {
+		err = tx.QueryRow(`INSERT INTO spend_policy_changes (addr_id, daily_limit, weekly_limit, tx_limit,
+					allowlist_only, effective)
+				VALUES ($1, $2, $3, $4, $5, now() + $6 * interval '1 second')
+				RETURNING to_char(effective, 'YYYY-MM-DD HH24:MI:SS');`,
+			addrID, next.DailyLimit, next.WeeklyLimit, next.TxLimit, next.AllowlistOnly,
+			spendCooldown.Seconds()).Scan(&next.Effective)
+		if err != nil {
+			tx.Rollback()
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		recordSpendEvent(tx, addrID, spendEventRequested, next.String()+", from "+next.Effective)
+		data["pending"] = next
+	} else {
+		recordSpendEvent(tx, addrID, spendEventUpdated, next.String())
+	}
+	if err = tx.Commit(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: data})
+}
+
+// cancelSpendPolicy - drops the pending change of an address
+func cancelSpendPolicy(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	addrID, err := ownerID(req.FormValue("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	result, err := walletDB.Exec("DELETE FROM spend_policy_changes WHERE addr_id = $1 AND effective > now();", addrID)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		encoder.Encode(jsonResponse{Status: "No pending change"})
+		return
+	}
+	recordSpendEvent(walletDB, addrID, spendEventCancelled, "")
+	encoder.Encode(jsonResponse{Status: "OK"})
+}
+
+// allowDestination - adds a destination to the allowlist of an address.
+// While the allowlist is enforced the entry only counts after
+// spendCooldown.
+func allowDestination(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	dest := strings.TrimSpace(req.FormValue("destination"))
+	label := strings.TrimSpace(req.FormValue("label"))
+	if _, err := decodeAddress(dest); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if len(label) > maxAllowedLabelLen {
+		encoder.Encode(jsonResponse{Status: "Label is too long"})
+		return
+	}
+	tx, addrID, err := lockAddress(req.FormValue("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	policy, err := currentPolicy(tx, addrID)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	var count int
+	err = tx.QueryRow("SELECT count(*) FROM allowed_destinations WHERE addr_id = $1;", addrID).Scan(&count)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if count >= maxAllowedDestinations {
+		encoder.Encode(jsonResponse{Status: "Too many allowed destinations"})
+		return
+	}
+	var cooldown float64
+	if policy.AllowlistOnly {
+		cooldown = spendCooldown.Seconds()
+	}
+	var effective string
+	err = tx.QueryRow(`INSERT INTO allowed_destinations (addr_id, address, label, effective)
+			VALUES ($1, $2, $3, now() + $4 * interval '1 second') ON CONFLICT (addr_id, address) DO NOTHING
+			RETURNING to_char(effective, 'YYYY-MM-DD HH24:MI:SS');`, addrID, dest, label, cooldown).Scan(&effective)
+	if err == sql.ErrNoRows {
+		encoder.Encode(jsonResponse{Status: "Destination is already on the allowlist"})
+		return
+	}
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	recordSpendEvent(tx, addrID, spendEventAllowAdd, dest+", from "+effective)
+	if err = tx.Commit(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"effective": effective}})
+}
+
+// disallowDestination - removes an entry from the allowlist of an address
+func disallowDestination(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: "Destination not found"})
+		return
+	}
+	addrID, err := ownerID(req.FormValue("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	var dest string
+	err = walletDB.QueryRow("DELETE FROM allowed_destinations WHERE id = $1 AND addr_id = $2 RETURNING address;",
+		id, addrID).Scan(&dest)
+	if err == sql.ErrNoRows {
+		encoder.Encode(jsonResponse{Status: "Destination not found"})
+		return
+	}
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	recordSpendEvent(walletDB, addrID, spendEventAllowRemove, dest)
+	encoder.Encode(jsonResponse{Status: "OK"})
+}
@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+// setPolicy - puts p in effect for address without a cooldown
+func setPolicy(t *testing.T, address string, p *spendPolicy) {
+	tx, addrID, err := lockAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storePolicy(tx, addrID, p); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reserved - atomic units counted as spent by address
+func reserved(t *testing.T, address string) int64 {
+	var spent int64
+	err := walletDB.QueryRow(`SELECT COALESCE(sum(amount), 0)::bigint FROM spends
+			WHERE addr_id = (SELECT id FROM addresses WHERE address = $1);`, address).Scan(&spent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spent
+}
+
+// refusedBy - the policy event err refused a send with, empty if it didn't
+func refusedBy(err error) string {
+	if serr, ok := err.(*spendError); ok {
+		return serr.event
+	}
+	return ""
+}
+
+func TestReserveAndSettle(t *testing.T) {
+	needDB(t)
+	address := createAddress(t)
+	defer dropAddress(t, address)
+	setPolicy(t, address, &spendPolicy{DailyLimit: 50, TxLimit: 40})
+
+	transfers := []Destination{{Address: randomAddress(), Amount: 3000}}
+	first, second := randomHex(16), randomHex(16)
+	if err := reserveSpend(address, first, transfers); err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+	if got := reserved(t, address); got != 3000 {
+		t.Errorf("reserved %d after the first send, want 3000", got)
+	}
+
+	// 30 and 30 are over the daily limit, refused sends reserve nothing
+	err := reserveSpend(address, second, transfers)
+	if refusedBy(err) != spendEventDailyLimit {
+		t.Errorf("second send = %v, want the daily limit", err)
+	}
+	if got := reserved(t, address); got != 3000 {
+		t.Errorf("reserved %d after a refused send, want 3000", got)
+	}
+
+	// only part of the first send went out
+	settleSpend(first, 1000)
+	if err = reserveSpend(address, second, transfers); err != nil {
+		t.Errorf("second send after settling the first: %v", err)
+	}
+	if got := reserved(t, address); got != 4000 {
+		t.Errorf("reserved %d after the second send, want 4000", got)
+	}
+
+	// nothing of the second went out
+	settleSpend(second, 0)
+	if got := reserved(t, address); got != 1000 {
+		t.Errorf("reserved %d after a failed send, want 1000", got)
+	}
+
+	err = reserveSpend(address, randomHex(16), []Destination{{Address: randomAddress(), Amount: 4001}})
+	if refusedBy(err) != spendEventTxLimit {
+		t.Errorf("send over the limit per send = %v, want the limit per send", err)
+	}
+	// without a reference transfers are only checked
+	if err = reserveSpend(address, "", transfers); err != nil {
+		t.Errorf("check without a reference: %v", err)
+	}
+	if got := reserved(t, address); got != 1000 {
+		t.Errorf("reserved %d after a check, want 1000", got)
+	}
+
+	var refused int
+	err = walletDB.QueryRow(`SELECT count(*) FROM spend_events WHERE event IN ($2, $3)
+			AND addr_id = (SELECT id FROM addresses WHERE address = $1);`,
+		address, spendEventDailyLimit, spendEventTxLimit).Scan(&refused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refused != 2 {
+		t.Errorf("%d refused sends recorded, want 2", refused)
+	}
+}
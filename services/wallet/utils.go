@@ -1,15 +1,75 @@
 package main
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"time"
+)
+
 type jsonResponse struct {
 	Status string
 	Data   map[string]interface{}
 }
 
 type transaction struct {
-	Destination string
-	Hash        string
-	Amount      string
-	Date        string
-	PaymentID   string
-	ID          string
+	Destination   string
+	Hash          string
+	Amount        string
+	Date          string
+	PaymentID     string
+	ID            string
+	BlockHeight   int64
+	UnlockTime    int64
+	Confirmations int64
+	State         string
+	Fusion        bool
+	Contact       string // address book name of Destination
+}
+
+// transaction states
+const (
+	txPending   = "pending"
+	txConfirmed = "confirmed"
+	txUnlocked  = "unlocked"
+)
+
+// randomHex - returns n random bytes hex encoded
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// parseAmount - converts an amount in coin units to atomic units
+func parseAmount(amount string) (int64, error) {
+	f, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Round(f * divisor)), nil
+}
+
+// setState - fills in the confirmations and state of tx given the number
+// of blocks in the chain
+func (tx *transaction) setState(blockCount int64) {
+	tx.Confirmations = blockCount - tx.BlockHeight
+	if tx.Confirmations < 0 {
+		tx.Confirmations = 0
+	}
+	unlocked := tx.Confirmations >= spendableAge
+	if tx.UnlockTime >= maxBlockNumber {
+		unlocked = unlocked && time.Now().Unix() >= tx.UnlockTime
+	} else {
+		unlocked = unlocked && blockCount-1 >= tx.UnlockTime
+	}
+	switch {
+	case unlocked:
+		tx.State = txUnlocked
+	case tx.Confirmations >= confirmationsRequired:
+		tx.State = txConfirmed
+	default:
+		tx.State = txPending
+	}
 }
@@ -1,202 +1,382 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 
-	"./turtlecoin-rpc-go/walletd"
 	_ "github.com/lib/pq"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 func main() {
+	configure()
+	// maintenance commands run without starting the service
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	startServices()
 	router := httprouter.New()
 	router.GET("/status/:address", getStatus)
 	router.GET("/delete/:address", deleteAddress)
 	router.GET("/create", newAddress)
+	router.POST("/import", importAddress)
+	router.POST("/watch", addWatchAddress)
+	router.GET("/watch/:address", getWatchAddresses)
+	router.POST("/watch/delete", deleteWatchAddress)
 	router.GET("/export_keys/:address", exportKeys)
-	router.GET("/transactions/:address/:n", getTransactions)
+	router.POST("/seed_backup", confirmSeedBackup)
+	router.GET("/transactions/:address", getTransactions)
+	router.GET("/pending/:address", getPending)
 	router.POST("/send_transaction", sendTransaction)
-	log.Fatal(http.ListenAndServe(hostPort, router))
-}
-
-// createWallet - creates a new wallet
-func createWallet() (string, error) {
-	response := map[string]interface{}{}
-	walletdResponse := walletd.CreateAddress(
-		rpcPwd,
-		"localhost",
-		rpcPort,
-	)
-	json.NewDecoder(walletdResponse).Decode(&response)
-	address := response["result"].(map[string]interface{})["address"].(string)
-	return address, nil
+	router.POST("/integrated_address", newIntegratedAddress)
+	router.GET("/integrated_addresses/:address", getIntegratedAddresses)
+	router.GET("/validate_address/:address", validateAddress)
+	router.POST("/send_batch", sendBatch)
+	router.POST("/webhooks", newWebhook)
+	router.POST("/webhooks/delete", deleteWebhook)
+	router.GET("/webhooks/:address", getWebhooks)
+	router.POST("/invoices", newInvoice)
+	router.GET("/invoices/:address", getInvoices)
+	router.GET("/invoice/:id", getInvoice)
+	router.POST("/optimize", optimizeWallet)
+	router.POST("/delayed_transaction", prepareTransaction)
+	router.POST("/delayed_transaction/send", confirmTransaction)
+	router.POST("/delayed_transaction/delete", cancelTransaction)
+	router.GET("/contacts/:address", getContacts)
+	router.POST("/contacts", saveContact)
+	router.POST("/contacts/delete", deleteContact)
+	router.GET("/export/:address", exportTransactions)
+	router.GET("/health", getHealth)
+	router.GET("/health/:address", getAddressHealth)
+	router.GET("/policy/:address", getSpendPolicy)
+	router.POST("/policy", saveSpendPolicy)
+	router.POST("/policy/cancel", cancelSpendPolicy)
+	router.POST("/policy/allow", allowDestination)
+	router.POST("/policy/allow/delete", disallowDestination)
+	router.GET("/admin/withdrawals", getWithdrawals)
+	router.GET("/withdrawals/:address", getAddressWithdrawals)
+	router.POST("/withdrawals/approve", approveWithdrawal)
+	router.POST("/withdrawals/reject", rejectWithdrawal)
+	srv := &http.Server{Addr: hostPort, Handler: router}
+	done := shutdownOnSignal(srv)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
 }
 
-// newAddress - creates an address for a new user
+// newAddress - creates an address for a new user on the backend chosen by
+// the BACKEND_POLICY, falling back to the others if it fails
 func newAddress(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	encoder := json.NewEncoder(res)
-	address, err := createWallet()
+	order, err := assignOrder()
 	if err != nil {
 		encoder.Encode(jsonResponse{Status: err.Error()})
 		return
 	}
-	walletDB.Exec("INSERT INTO addresses (address) VALUES ($1);", address)
-	data := map[string]interface{}{"address": address}
-	encoder.Encode(jsonResponse{Status: "OK", Data: data})
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	for _, service := range order {
+		var address string
+		address, err = service.backend.CreateAddress(ctx)
+		if err != nil {
+			fmt.Println("create address", service.name+":", err)
+			continue
+		}
+		_, err = walletDB.Exec("INSERT INTO addresses (address, backend) VALUES ($1, $2);",
+			address, service.name)
+		if err != nil {
+			break
+		}
+		data := map[string]interface{}{"address": address}
+		encoder.Encode(jsonResponse{Status: "OK", Data: data})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: err.Error()})
 }
 
 // deleteAddress - removes address from container
 func deleteAddress(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
 	address := p.ByName("address")
-	walletd.DeleteAddress(
-		rpcPwd,
-		"localhost",
-		rpcPort,
-		address,
-	)
+	backend, err := backendFor(address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	if err := backend.DeleteAddress(ctx, address); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
 	walletDB.Exec(`DELETE FROM transactions
 			WHERE addr_id = (SELECT id FROM addresses WHERE address = $1);`, address)
 	walletDB.Exec("DELETE FROM addresses WHERE address = $1;", address)
+	encoder.Encode(jsonResponse{Status: "OK"})
 }
 
 // getStatus - gets the balance and status of a wallet
 func getStatus(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
-	address := p.ByName("address")
-	response := jsonResponse{Data: map[string]interface{}{}}
-	temp := make(map[string]interface{}, 1)
-	walletdResponse := walletd.GetBalance(
-		rpcPwd,
-		"localhost",
-		rpcPort,
-		address,
-	)
-	json.NewDecoder(walletdResponse).Decode(&temp)
-	trtl := temp["result"].(map[string]interface{})["availableBalance"].(float64) / divisor
-	temp["result"].(map[string]interface{})["availableBalance"] = trtl
-	trtl = temp["result"].(map[string]interface{})["lockedAmount"].(float64)
-	temp["result"].(map[string]interface{})["lockedAmount"] = trtl / divisor
-
-	response.Data["balance"] = temp["result"]
-	walletdResponse = walletd.GetStatus(
-		rpcPwd,
-		"localhost",
-		rpcPort,
-	)
-	json.NewDecoder(walletdResponse).Decode(&temp)
-	response.Data["status"] = temp["result"]
-	json.NewEncoder(res).Encode(response)
+	encoder := json.NewEncoder(res)
+	backend, err := backendFor(p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	balance, err := backend.GetBalance(ctx, p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	status, err := backend.GetStatus(ctx)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	var seedBackup string // date the seed was last confirmed, empty if never
+	err = walletDB.QueryRow("SELECT COALESCE(to_char(seed_backup, 'YYYY-MM-DD'), '') FROM addresses WHERE address = $1;",
+		p.ByName("address")).Scan(&seedBackup)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{
+		"balance": map[string]interface{}{
+			"availableBalance": float64(balance.AvailableBalance) / divisor,
+			"lockedAmount":     float64(balance.LockedAmount) / divisor,
+		},
+		"status":     status,
+		"seedBackup": seedBackup,
+	}})
 }
 
-// sendTransaction - sends a transaction from address to dest
-func sendTransaction(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+// parseSendRequest - validates the form of a send
+func parseSendRequest(req *http.Request) (*sendRequest, error) {
 	dest := req.FormValue("destination")
 	amountStr := req.FormValue("amount")
 	paymentID := req.FormValue("payment_id")
-	address := req.FormValue("address")
-	extra := "" // TODO - use for messages
-	response := jsonResponse{}
-	if matched, _ := regexp.MatchString(addressFormat, dest); !matched {
-		json.NewEncoder(res).Encode(jsonResponse{Status: "Incorrect Address Format"})
-		return
+	requestKey := req.FormValue("request_key")
+	if matched, _ := regexp.MatchString(requestKeyFormat, requestKey); !matched {
+		return nil, errors.New("Missing or Incorrect Request Key")
 	}
-	if matched, _ := regexp.MatchString("^[0-9]+\\.{0,1}[0-9]{0,2}$", amountStr); !matched {
-		json.NewEncoder(res).Encode(jsonResponse{Status: "Incorrect Amount Format"})
-		return
+	if matched, _ := regexp.MatchString(amountFormat, amountStr); !matched {
+		return nil, errors.New("Incorrect Amount Format")
 	}
-	if matched, _ := regexp.MatchString("^[a-fA-F0-9]{64}$", paymentID); !matched && paymentID != "" {
-		json.NewEncoder(res).Encode(jsonResponse{Status: "Incorrect Payment ID Format"})
-		return
+	if matched, _ := regexp.MatchString(paymentIDFormat, paymentID); !matched && paymentID != "" {
+		return nil, errors.New("Incorrect Payment ID Format")
 	}
-	amount, _ := strconv.ParseFloat(amountStr, 10)
-	amount *= divisor
-	walletdResponse := walletd.SendTransaction(
-		rpcPwd,
-		"localhost",
-		rpcPort,
-		[]string{address},
-		[]map[string]interface{}{
-			{
-				"amount":  int64(amount),
-				"address": dest,
-			},
-		},
-		transactionFee, // fee
-		0,              // unlock time
-		3,              // mixin
-		extra,
-		paymentID,
-		"", // change address
-	)
-	json.NewDecoder(walletdResponse).Decode(&response.Data)
-	if message, ok := response.Data["error"]; ok {
-		response.Status = message.(map[string]interface{})["message"].(string)
-	} else {
-		response.Status = "OK"
+	if _, err := checkDestination(dest, paymentID); err != nil {
+		return nil, err
 	}
-	json.NewEncoder(res).Encode(response)
+	amount, _ := parseAmount(amountStr)
+	return &sendRequest{
+		key:       requestKey,
+		address:   req.FormValue("address"),
+		dest:      dest,
+		amount:    amount,
+		paymentID: paymentID,
+	}, nil
 }
 
-// getTransactions - gets transaction history from the database
-func getTransactions(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+// sendTransaction - sends a transaction from address to dest
+func sendTransaction(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	encoder := json.NewEncoder(res)
-	rows, err := walletDB.Query(`SELECT dest, hash, amount, paymentID, id FROM transactions
-								 WHERE addr_id = (SELECT id FROM addresses WHERE address = $1) AND id > $2 ORDER BY id DESC LIMIT 15;`,
-		p.ByName("address"), p.ByName("n"))
-
+	extra := "" // TODO - use for messages
+	request, err := parseSendRequest(req)
 	if err != nil {
 		encoder.Encode(jsonResponse{Status: err.Error()})
 		return
 	}
-
-	var tmp string
-	txs := make([]transaction, 0)
-	for rows.Next() {
-		tx := transaction{}
-		err := rows.Scan(&tmp, &tx.Hash, &tx.Amount, &tx.PaymentID, &tx.ID)
+	backend, err := senderFor(request.address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	hash, fresh, err := beginSend(request)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if !fresh {
+		encoder.Encode(jsonResponse{Status: "OK",
+			Data: map[string]interface{}{"transactionHash": hash, "duplicate": true}})
+		return
+	}
+	transfers := []Destination{{Address: request.dest, Amount: request.amount}}
+	if err = reserveSpend(request.address, request.key, transfers); err != nil {
+		finishSend(request, "", err)
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if needsApproval(request.amount) {
+		id, err := holdWithdrawal(request)
 		if err != nil {
+			settleSpend(request.key, 0)
+			finishSend(request, "", err)
 			encoder.Encode(jsonResponse{Status: err.Error()})
 			return
 		}
-		if tmp[0] != ' ' {
-			tx.Destination = tmp
-		}
-		txs = append(txs, tx)
+		encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"held": id}})
+		return
 	}
 
-	if err = rows.Err(); err != nil {
+	// not bound to the request, the outcome has to be recorded even if
+	// the caller goes away
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	hash, err = backend.SendTransaction(ctx, &TransactionRequest{
+		Addresses: []string{request.address},
+		Transfers: transfers,
+		Fee:       transactionFee,
+		Anonymity: 3, // mixin
+		Extra:     extra,
+		PaymentID: request.paymentID,
+	})
+	if err != nil && ctx.Err() != nil {
+		err = errSendUnknown
+	}
+	if err != nil && err != errSendUnknown {
+		settleSpend(request.key, 0)
+	}
+	finishSend(request, hash, err)
+	if err != nil {
 		encoder.Encode(jsonResponse{Status: err.Error()})
 		return
 	}
 	encoder.Encode(jsonResponse{Status: "OK",
-		Data: map[string]interface{}{"transactions": txs}})
+		Data: map[string]interface{}{"transactionHash": hash}})
+}
+
+// getTransactions - gets a page of the transaction history of an address,
+// newest first. Takes the cursor and limit of the page and the filters
+// direction, min, max, payment_id, hash (prefix), from and to as parameters.
+func getTransactions(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	filter, err := parseHistoryFilter(req)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	cursor, err := decodeCursor(req.FormValue("cursor"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	limit, err := parsePageSize(req)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	backend, err := backendFor(p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	status, err := backend.GetStatus(ctx)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	txs, next, prev, err := queryHistory(p.ByName("address"), filter, cursor, limit)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	for i := range txs {
+		txs[i].setState(status.BlockCount)
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{
+		"transactions": txs,
+		"blockCount":   status.BlockCount,
+		"next":         next,
+		"prev":         prev,
+	}})
 }
 
 // exportKeys - exports the spend and view key
 func exportKeys(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
 	encoder := json.NewEncoder(res)
-	address := p.ByName("address")
-	response := jsonResponse{Status: "OK", Data: map[string]interface{}{}}
-	key := map[string]interface{}{}
-	walletdResponse := walletd.GetViewKey(
-		rpcPwd,
-		"localhost",
-		rpcPort,
-	)
-	json.NewDecoder(walletdResponse).Decode(&key)
-	response.Data["viewKey"] = key["result"].(map[string]interface{})["viewSecretKey"].(string)
-	walletdResponse = walletd.GetSpendKeys(
-		rpcPwd,
-		"localhost",
-		rpcPort,
-		address,
-	)
-	json.NewDecoder(walletdResponse).Decode(&key)
-	response.Data["spendPublicKey"] = key["result"].(map[string]interface{})["spendPublicKey"].(string)
-	response.Data["spendSecretKey"] = key["result"].(map[string]interface{})["spendSecretKey"].(string)
-	encoder.Encode(response)
+	backend, err := spenderFor(p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	viewKey, err := backend.GetViewKey(ctx)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	keys, err := backend.GetSpendKeys(ctx, p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	data := map[string]interface{}{
+		"viewKey":        viewKey,
+		"spendPublicKey": keys.SpendPublicKey,
+		"spendSecretKey": keys.SpendSecretKey,
+	}
+	// addresses sharing the view key of their container have no seed
+	if seed, err := backend.GetMnemonicSeed(ctx, p.ByName("address")); err != nil {
+		data["mnemonicError"] = err.Error()
+	} else {
+		data["mnemonicSeed"] = seed
+		data["quizSize"] = mnemonicQuizSize
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: data})
+}
+
+// confirmSeedBackup - marks the mnemonic seed of address as backed up once
+// the words asked at the given positions match it
+func confirmSeedBackup(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	if err := req.ParseForm(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	address := req.FormValue("address")
+	positions := []int{}
+	for _, v := range req.Form["position"] {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			encoder.Encode(jsonResponse{Status: "Incorrect word position"})
+			return
+		}
+		positions = append(positions, p)
+	}
+	backend, err := spenderFor(address)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	seed, err := backend.GetMnemonicSeed(ctx, address)
+	if err == nil {
+		err = checkMnemonicQuiz(seed, positions, req.Form["word"])
+	}
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if _, err = walletDB.Exec("UPDATE addresses SET seed_backup = now() WHERE address = $1;", address); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK"})
 }
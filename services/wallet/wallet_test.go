@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// testDB - whether the tests reach the tx_history database
+var testDB bool
+
+// Tests of the handlers need the tx_history database and run against the
+// fake backends when it is set up like for the service:
+// DB_USER=<user> DB_PWD=<pwd> go test. The others run without it.
+func TestMain(m *testing.M) {
+	if os.Getenv("DB_USER") != "" {
+		os.Setenv("WALLET_BACKEND", "fake")
+		configure()
+		for _, service := range serviceList {
+			// sends are refused until a backend was seen healthy
+			status, err := service.backend.GetStatus(context.Background())
+			service.updateHealth(status, err)
+		}
+		holdThreshold = 0
+		testDB = true
+	}
+	os.Exit(m.Run())
+}
+
+// needDB - skips a test that needs the database when it isn't set up
+func needDB(t *testing.T) {
+	if !testDB {
+		t.Skip("set DB_USER and DB_PWD to run the tests using tx_history")
+	}
+}
+
+// serve - runs handler on a request with form and the route params
+func serve(t *testing.T, handler httprouter.Handle, form url.Values, params ...httprouter.Param) jsonResponse {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler(rec, req, httprouter.Params(params))
+	var response jsonResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("response is not json: %v", err)
+	}
+	return response
+}
+
+// createAddress - a new address credited with fakeFaucet, delete it with
+// dropAddress
+func createAddress(t *testing.T) string {
+	response := serve(t, newAddress, nil)
+	if response.Status != "OK" {
+		t.Fatalf("create: %s", response.Status)
+	}
+	address, _ := response.Data["address"].(string)
+	if address == "" {
+		t.Fatal("create: no address")
+	}
+	return address
+}
+
+func dropAddress(t *testing.T, address string) {
+	if response := serve(t, deleteAddress, nil, httprouter.Param{Key: "address", Value: address}); response.Status != "OK" {
+		t.Errorf("delete %s: %s", address, response.Status)
+	}
+}
+
+// availableBalance - the available balance getStatus reports for address
+func availableBalance(t *testing.T, address string) float64 {
+	response := serve(t, getStatus, nil, httprouter.Param{Key: "address", Value: address})
+	if response.Status != "OK" {
+		t.Fatalf("status: %s", response.Status)
+	}
+	balance, _ := response.Data["balance"].(map[string]interface{})
+	available, ok := balance["availableBalance"].(float64)
+	if !ok {
+		t.Fatalf("status: no available balance in %v", response.Data)
+	}
+	return available
+}
+
+func TestCreateAndStatus(t *testing.T) {
+	needDB(t)
+	address := createAddress(t)
+	defer dropAddress(t, address)
+
+	if _, err := decodeAddress(address); err != nil {
+		t.Errorf("created address does not decode: %v", err)
+	}
+	if got := availableBalance(t, address); got != fakeFaucet/divisor {
+		t.Errorf("balance = %v, want %v", got, fakeFaucet/divisor)
+	}
+	response := serve(t, getStatus, nil, httprouter.Param{Key: "address", Value: address})
+	if seedBackup := response.Data["seedBackup"]; seedBackup != "" {
+		t.Errorf("seedBackup = %v, want none", seedBackup)
+	}
+
+	other := createAddress(t)
+	dropAddress(t, other)
+	response = serve(t, getStatus, nil, httprouter.Param{Key: "address", Value: other})
+	if response.Status != errUnknownAddress.Error() {
+		t.Errorf("status of a deleted address = %q, want %q", response.Status, errUnknownAddress)
+	}
+}
+
+func TestSend(t *testing.T) {
+	needDB(t)
+	address := createAddress(t)
+	defer dropAddress(t, address)
+	dest := createAddress(t)
+	defer dropAddress(t, dest)
+
+	form := url.Values{
+		"address":     {address},
+		"destination": {dest},
+		"amount":      {"10.50"},
+		"request_key": {randomHex(16)},
+	}
+	response := serve(t, sendTransaction, form)
+	if response.Status != "OK" {
+		t.Fatalf("send: %s", response.Status)
+	}
+	hash, _ := response.Data["transactionHash"].(string)
+	if hash == "" {
+		t.Fatal("send: no transaction hash")
+	}
+	want := float64(fakeFaucet-1050-transactionFee) / divisor
+	if got := availableBalance(t, address); got != want {
+		t.Errorf("balance after send = %v, want %v", got, want)
+	}
+
+	// the same request key answers with the first outcome instead of sending again
+	response = serve(t, sendTransaction, form)
+	if response.Status != "OK" || response.Data["transactionHash"] != hash || response.Data["duplicate"] != true {
+		t.Errorf("resend = %s %v, want the first hash as duplicate", response.Status, response.Data)
+	}
+	if got := availableBalance(t, address); got != want {
+		t.Errorf("balance after resend = %v, want %v", got, want)
+	}
+	form.Set("amount", "11")
+	if response = serve(t, sendTransaction, form); response.Status != errKeyReused.Error() {
+		t.Errorf("reused key = %q, want %q", response.Status, errKeyReused)
+	}
+
+	for name, values := range map[string]url.Values{
+		"Incorrect Amount Format":          {"amount": {"1.234"}},
+		"Incorrect Payment ID Format":      {"payment_id": {"abc"}},
+		"Wrong amount":                     {"amount": {"2000"}},
+		"Missing or Incorrect Request Key": {"request_key": {"key"}},
+		errAddressChecksum.Error():         {"destination": {typo(dest)}},
+	} {
+		form := url.Values{"address": {address}, "destination": {dest}, "amount": {"1"},
+			"request_key": {randomHex(16)}}
+		for k, v := range values {
+			form[k] = v
+		}
+		if response = serve(t, sendTransaction, form); response.Status != name {
+			t.Errorf("send with %v = %q, want %q", values, response.Status, name)
+		}
+	}
+}
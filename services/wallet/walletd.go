@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// rpcClient - WalletBackend talking json-rpc to a turtle-service instance
+type rpcClient struct {
+	uri      string
+	password string
+	client   *http.Client
+}
+
+// rpcError - error object returned by walletd
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// newRPCClient - creates a client for the walletd at host:port
+func newRPCClient(host string, port int, password string) *rpcClient {
+	return &rpcClient{
+		uri:      "http://" + host + ":" + strconv.Itoa(port) + "/json_rpc",
+		password: password,
+		client:   &http.Client{},
+	}
+}
+
+// call - performs a json-rpc request and decodes the result into out
+func (c *rpcClient) call(ctx context.Context, method string, params, out interface{}) error {
+	if params == nil {
+		params = struct{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc":  "2.0",
+		"id":       1,
+		"password": c.password,
+		"method":   method,
+		"params":   params,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reply := struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("walletd %s: %v", method, err)
+	}
+	if reply.Error != nil {
+		return reply.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, out)
+}
+
+// CreateAddress - creates a new address in the container
+func (c *rpcClient) CreateAddress(ctx context.Context) (string, error) {
+	result := struct {
+		Address string `json:"address"`
+	}{}
+	if err := c.call(ctx, "createAddress", nil, &result); err != nil {
+		return "", err
+	}
+	return result.Address, nil
+}
+
+// ImportAddress - adds the address of an existing spend secret key to the
+// container, walletd looks for its transfers from scanHeight on
+func (c *rpcClient) ImportAddress(ctx context.Context, spendSecretKey string, scanHeight int64) (string, error) {
+	result := struct {
+		Address string `json:"address"`
+	}{}
+	err := c.call(ctx, "createAddress", map[string]interface{}{
+		"spendSecretKey": spendSecretKey,
+		"scanHeight":     scanHeight,
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.Address, nil
+}
+
+// CreateTrackingAddress - adds a view only address for spendPublicKey to a
+// tracking container, walletd looks for its incoming transfers from
+// scanHeight on
+func (c *rpcClient) CreateTrackingAddress(ctx context.Context, spendPublicKey string, scanHeight int64) (string, error) {
+	result := struct {
+		Address string `json:"address"`
+	}{}
+	err := c.call(ctx, "createAddress", map[string]interface{}{
+		"spendPublicKey": spendPublicKey,
+		"scanHeight":     scanHeight,
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.Address, nil
+}
+
+// CreateIntegratedAddress - combines an address and a payment id
+func (c *rpcClient) CreateIntegratedAddress(ctx context.Context, address, paymentID string) (string, error) {
+	result := struct {
+		IntegratedAddress string `json:"integratedAddress"`
+	}{}
+	err := c.call(ctx, "createIntegratedAddress", map[string]interface{}{
+		"address":   address,
+		"paymentId": paymentID,
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.IntegratedAddress, nil
+}
+
+// DeleteAddress - removes an address from the container
+func (c *rpcClient) DeleteAddress(ctx context.Context, address string) error {
+	return c.call(ctx, "deleteAddress", map[string]interface{}{"address": address}, nil)
+}
+
+// GetBalance - gets the balance of an address
+func (c *rpcClient) GetBalance(ctx context.Context, address string) (*Balance, error) {
+	balance := &Balance{}
+	err := c.call(ctx, "getBalance", map[string]interface{}{"address": address}, balance)
+	if err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// GetStatus - gets the sync status of the container
+func (c *rpcClient) GetStatus(ctx context.Context) (*Status, error) {
+	status := &Status{}
+	if err := c.call(ctx, "getStatus", nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// GetTransactions - gets the container transactions in a block range
+func (c *rpcClient) GetTransactions(ctx context.Context, firstBlockIndex, blockCount int64) ([]Block, error) {
+	result := struct {
+		Items []Block `json:"items"`
+	}{}
+	err := c.call(ctx, "getTransactions", map[string]interface{}{
+		"firstBlockIndex": firstBlockIndex,
+		"blockCount":      blockCount,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Items, nil
+}
+
+// GetBlockHashes - gets the hashes of the blocks in a range
+func (c *rpcClient) GetBlockHashes(ctx context.Context, firstBlockIndex, blockCount int64) ([]string, error) {
+	result := struct {
+		BlockHashes []string `json:"blockHashes"`
+	}{}
+	err := c.call(ctx, "getBlockHashes", map[string]interface{}{
+		"firstBlockIndex": firstBlockIndex,
+		"blockCount":      blockCount,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.BlockHashes, nil
+}
+
+// GetUnconfirmedTransactionHashes - gets the hashes of the container
+// transactions still in the mempool, for all addresses if none are given
+func (c *rpcClient) GetUnconfirmedTransactionHashes(ctx context.Context, addresses []string) ([]string, error) {
+	result := struct {
+		TransactionHashes []string `json:"transactionHashes"`
+	}{}
+	params := map[string]interface{}{}
+	if len(addresses) > 0 {
+		params["addresses"] = addresses
+	}
+	if err := c.call(ctx, "getUnconfirmedTransactionHashes", params, &result); err != nil {
+		return nil, err
+	}
+	return result.TransactionHashes, nil
+}
+
+// GetTransaction - gets a single container transaction
+func (c *rpcClient) GetTransaction(ctx context.Context, hash string) (*Transaction, error) {
+	result := struct {
+		Transaction Transaction `json:"transaction"`
+	}{}
+	err := c.call(ctx, "getTransaction", map[string]interface{}{"transactionHash": hash}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result.Transaction, nil
+}
+
+// SendTransaction - sends a transaction and returns its hash
+func (c *rpcClient) SendTransaction(ctx context.Context, tx *TransactionRequest) (string, error) {
+	result := struct {
+		TransactionHash string `json:"transactionHash"`
+	}{}
+	if err := c.call(ctx, "sendTransaction", tx, &result); err != nil {
+		return "", err
+	}
+	return result.TransactionHash, nil
+}
+
+// CreateDelayedTransaction - builds a transaction without relaying it
+func (c *rpcClient) CreateDelayedTransaction(ctx context.Context, tx *TransactionRequest) (string, error) {
+	result := struct {
+		TransactionHash string `json:"transactionHash"`
+	}{}
+	if err := c.call(ctx, "createDelayedTransaction", tx, &result); err != nil {
+		return "", err
+	}
+	return result.TransactionHash, nil
+}
+
+// GetDelayedTransactionHashes - hashes of the transactions created but not sent
+func (c *rpcClient) GetDelayedTransactionHashes(ctx context.Context) ([]string, error) {
+	result := struct {
+		TransactionHashes []string `json:"transactionHashes"`
+	}{}
+	if err := c.call(ctx, "getDelayedTransactionHashes", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.TransactionHashes, nil
+}
+
+// SendDelayedTransaction - relays a delayed transaction
+func (c *rpcClient) SendDelayedTransaction(ctx context.Context, hash string) error {
+	return c.call(ctx, "sendDelayedTransaction", map[string]interface{}{"transactionHash": hash}, nil)
+}
+
+// DeleteDelayedTransaction - drops a delayed transaction and unlocks its inputs
+func (c *rpcClient) DeleteDelayedTransaction(ctx context.Context, hash string) error {
+	return c.call(ctx, "deleteDelayedTransaction", map[string]interface{}{"transactionHash": hash}, nil)
+}
+
+// EstimateFusion - counts the outputs of addresses below threshold that can be fused
+func (c *rpcClient) EstimateFusion(ctx context.Context, threshold int64, addresses []string) (*FusionEstimate, error) {
+	estimate := &FusionEstimate{}
+	err := c.call(ctx, "estimateFusion", map[string]interface{}{
+		"threshold": threshold,
+		"addresses": addresses,
+	}, estimate)
+	if err != nil {
+		return nil, err
+	}
+	return estimate, nil
+}
+
+// SendFusionTransaction - sends a fusion transaction and returns its hash
+func (c *rpcClient) SendFusionTransaction(ctx context.Context, tx *FusionRequest) (string, error) {
+	result := struct {
+		TransactionHash string `json:"transactionHash"`
+	}{}
+	if err := c.call(ctx, "sendFusionTransaction", tx, &result); err != nil {
+		return "", err
+	}
+	return result.TransactionHash, nil
+}
+
+// GetViewKey - gets the private view key of the container
+func (c *rpcClient) GetViewKey(ctx context.Context) (string, error) {
+	result := struct {
+		ViewSecretKey string `json:"viewSecretKey"`
+	}{}
+	if err := c.call(ctx, "getViewKey", nil, &result); err != nil {
+		return "", err
+	}
+	return result.ViewSecretKey, nil
+}
+
+// GetSpendKeys - gets the spend keys of an address
+func (c *rpcClient) GetSpendKeys(ctx context.Context, address string) (*SpendKeys, error) {
+	keys := &SpendKeys{}
+	err := c.call(ctx, "getSpendKeys", map[string]interface{}{"address": address}, keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetMnemonicSeed - gets the mnemonic seed of an address, only deterministic
+// addresses whose view key derives from their spend key have one
+func (c *rpcClient) GetMnemonicSeed(ctx context.Context, address string) (string, error) {
+	result := struct {
+		MnemonicSeed string `json:"mnemonicSeed"`
+	}{}
+	err := c.call(ctx, "getMnemonicSeed", map[string]interface{}{"address": address}, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.MnemonicSeed, nil
+}
+
+// Save - saves the container to disk
+func (c *rpcClient) Save(ctx context.Context) error {
+	return c.call(ctx, "save", nil, nil)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxWatchAddresses = 20 // per address
+	maxWatchLabelLen  = 64
+)
+
+var errWatchOnly = errors.New("Address is watch-only")
+
+type watchAddress struct {
+	Address   string
+	Label     string
+	Balance   float64 // received, spends can't be seen without the spend key
+	Locked    float64
+	Error     string // why the balance is missing
+	ScanUntil int64  // end of the backfill still running, 0 once done
+}
+
+// spenderFor - the walletd backend holding address, refused for watch-only
+// addresses as their container can't sign anything for them
+func spenderFor(address string) (WalletBackend, error) {
+	var watchOnly bool
+	err := walletDB.QueryRow("SELECT watch_only FROM addresses WHERE address = $1;", address).Scan(&watchOnly)
+	if err == sql.ErrNoRows {
+		return nil, errUnknownAddress
+	}
+	if err != nil {
+		return nil, err
+	}
+	if watchOnly {
+		return nil, errWatchOnly
+	}
+	return backendFor(address)
+}
+
+// ownerID - id of a spending address that watch-only addresses belong to
+func ownerID(address string) (int, error) {
+	var id int
+	var watchOnly bool
+	err := walletDB.QueryRow("SELECT id, watch_only FROM addresses WHERE address = $1;", address).Scan(&id, &watchOnly)
+	if err == sql.ErrNoRows {
+		return 0, errUnknownAddress
+	}
+	if err == nil && watchOnly {
+		err = errWatchOnly
+	}
+	return id, err
+}
+
+// trackingServices - the tracking containers whose view key is viewKey
+func trackingServices(ctx context.Context, viewKey string) []*TurtleService {
+	matches := []*TurtleService{}
+	for _, service := range serviceList {
+		if !service.watchOnly {
+			continue
+		}
+		key, err := service.backend.GetViewKey(ctx)
+		if err != nil {
+			fmt.Println("watch", service.name+":", err)
+			continue
+		}
+		if strings.EqualFold(key, viewKey) {
+			matches = append(matches, service)
+		}
+	}
+	return matches
+}
+
+// addWatchAddress - adds a watch-only address to the ones of address. Its
+// keys have to match a tracking container, which records its incoming
+// transfers from scan_height on.
+func addWatchAddress(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	watched := strings.TrimSpace(req.FormValue("watch_address"))
+	spendPublicKey := strings.ToLower(strings.TrimSpace(req.FormValue("spend_public_key")))
+	viewKey := strings.ToLower(strings.TrimSpace(req.FormValue("view_key")))
+	label := strings.TrimSpace(req.FormValue("label"))
+	addr, err := decodeAddress(watched)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if addr.integrated() {
+		encoder.Encode(jsonResponse{Status: "Watch a standard address, not an integrated one"})
+		return
+	}
+	if !spendKeyFormat.MatchString(spendPublicKey) {
+		encoder.Encode(jsonResponse{Status: "Public spend key must be 64 hex characters"})
+		return
+	}
+	if !spendKeyFormat.MatchString(viewKey) {
+		encoder.Encode(jsonResponse{Status: "Private view key must be 64 hex characters"})
+		return
+	}
+	if len(label) > maxWatchLabelLen {
+		encoder.Encode(jsonResponse{Status: "Label is too long"})
+		return
+	}
+	var scanHeight int64
+	if v := req.FormValue("scan_height"); v != "" {
+		if scanHeight, err = strconv.ParseInt(v, 10, 64); err != nil || scanHeight < 0 {
+			encoder.Encode(jsonResponse{Status: "Scan height must be a block number"})
+			return
+		}
+	}
+	owner, err := ownerID(req.FormValue("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	var count int
+	err = walletDB.QueryRow("SELECT count(*) FROM watch_addresses WHERE owner_id = $1;", owner).Scan(&count)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if count >= maxWatchAddresses {
+		encoder.Encode(jsonResponse{Status: "Too many watch-only addresses"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	matches := trackingServices(ctx, viewKey)
+	if len(matches) == 0 {
+		encoder.Encode(jsonResponse{Status: "No tracking container has this view key, ask the operator to add one"})
+		return
+	}
+	link := func(tx *sql.Tx, addrID int) error {
+		_, err := tx.Exec(`INSERT INTO watch_addresses (owner_id, addr_id, label) VALUES ($1, $2, $3)
+				ON CONFLICT (owner_id, addr_id) DO UPDATE SET label = EXCLUDED.label;`, owner, addrID, label)
+		return err
+	}
+
+	// already watched by someone with the same view key
+	var addrID int
+	var backend string
+	err = walletDB.QueryRow("SELECT id, backend FROM addresses WHERE address = $1 AND watch_only;",
+		watched).Scan(&addrID, &backend)
+	if err == nil {
+		for _, service := range matches {
+			if service.name == strings.TrimSpace(backend) {
+				if err = linkWatchAddress(link, addrID); err != nil {
+					encoder.Encode(jsonResponse{Status: err.Error()})
+					return
+				}
+				encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"address": watched}})
+				return
+			}
+		}
+		encoder.Encode(jsonResponse{Status: "No tracking container has this view key, ask the operator to add one"})
+		return
+	}
+	if err != sql.ErrNoRows {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+
+	service := matches[0]
+	address, err := service.backend.CreateTrackingAddress(ctx, spendPublicKey, scanHeight)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if address != watched {
+		err = fmt.Errorf("Address does not match the keys, they give %s", address)
+	} else {
+		err = registerExisting(service.name, address, true, scanHeight, link)
+	}
+	if err != nil {
+		if derr := service.backend.DeleteAddress(ctx, address); derr != nil {
+			fmt.Println("watch", service.name+":", derr)
+		}
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"address": address}})
+}
+
+// linkWatchAddress - runs link for a watch-only address already stored
+func linkWatchAddress(link func(tx *sql.Tx, addrID int) error, addrID int) error {
+	tx, err := walletDB.Begin()
+	if err != nil {
+		return err
+	}
+	if err = link(tx, addrID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// getWatchAddresses - lists the watch-only addresses of an address with
+// their balances
+func getWatchAddresses(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	rows, err := walletDB.Query(`SELECT a.address, w.label, COALESCE(b.until_height, 0)
+			FROM watch_addresses w JOIN addresses a ON a.id = w.addr_id
+			LEFT JOIN backfills b ON b.addr_id = a.id
+			WHERE w.owner_id = (SELECT id FROM addresses WHERE address = $1)
+			ORDER BY lower(w.label), a.address;`, p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	defer rows.Close()
+	watched := make([]watchAddress, 0)
+	for rows.Next() {
+		w := watchAddress{}
+		if err = rows.Scan(&w.Address, &w.Label, &w.ScanUntil); err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		w.Address = strings.TrimSpace(w.Address)
+		watched = append(watched, w)
+	}
+	if err = rows.Err(); err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+	defer cancel()
+	for i := range watched {
+		backend, err := backendFor(watched[i].Address)
+		var balance *Balance
+		if err == nil {
+			balance, err = backend.GetBalance(ctx, watched[i].Address)
+		}
+		if err != nil {
+			watched[i].Error = err.Error()
+			continue
+		}
+		watched[i].Balance = float64(balance.AvailableBalance) / divisor
+		watched[i].Locked = float64(balance.LockedAmount) / divisor
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"watch": watched}})
+}
+
+// deleteWatchAddress - removes a watch-only address from the ones of
+// address, and from its container once nobody watches it anymore
+func deleteWatchAddress(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	watched := strings.TrimSpace(req.FormValue("watch_address"))
+	result, err := walletDB.Exec(`DELETE FROM watch_addresses
+			WHERE owner_id = (SELECT id FROM addresses WHERE address = $1)
+			AND addr_id = (SELECT id FROM addresses WHERE address = $2);`,
+		req.FormValue("address"), watched)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		encoder.Encode(jsonResponse{Status: "Watch-only address not found"})
+		return
+	}
+	var watchers int
+	err = walletDB.QueryRow(`SELECT count(*) FROM watch_addresses
+			WHERE addr_id = (SELECT id FROM addresses WHERE address = $1);`, watched).Scan(&watchers)
+	if err != nil || watchers > 0 {
+		encoder.Encode(jsonResponse{Status: "OK"})
+		return
+	}
+	backend, err := backendFor(watched)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(req.Context(), rpcTimeout)
+		defer cancel()
+		err = backend.DeleteAddress(ctx, watched)
+	}
+	if err != nil {
+		// the address stays in the container and the database, unwatched
+		fmt.Println("watch:", watched, err)
+		encoder.Encode(jsonResponse{Status: "OK"})
+		return
+	}
+	walletDB.Exec(`DELETE FROM transactions
+			WHERE addr_id = (SELECT id FROM addresses WHERE address = $1);`, watched)
+	walletDB.Exec("DELETE FROM addresses WHERE address = $1;", watched)
+	encoder.Encode(jsonResponse{Status: "OK"})
+}
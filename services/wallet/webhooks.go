@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxWebhooks         = 5 // per address
+	maxConfirmations    = 1000
+	webhookMaxAttempts  = 10
+	webhookBaseDelay    = 30       // seconds before the first retry, doubled on every attempt
+	webhookMaxDelay     = 6 * 3600 // seconds
+	webhookBatch        = 20       // deliveries attempted per dispatcher round
+	webhookInterval     = 5 * time.Second
+	webhookTimeout      = 10 * time.Second
+	eventPaymentRecv    = "payment.received"
+	eventPaymentConfirm = "payment.confirmed"
+	eventPaymentReverse = "payment.reversed"
+)
+
+// privateNets - destinations webhooks may not reach unless WEBHOOK_ALLOW_PRIVATE is set
+var privateNets = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10",
+}
+
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+type webhook struct {
+	ID            int
+	URL           string
+	Secret        string
+	Confirmations int
+	Created       string
+	Deliveries    []webhookDelivery
+}
+
+type webhookDelivery struct {
+	ID           int
+	Hash         string
+	Event        string
+	Status       string
+	Attempts     int
+	ResponseCode int
+	LastError    string
+	Created      string
+	NextAttempt  string
+}
+
+// webhookPayload - the json body posted to webhooks
+type webhookPayload struct {
+	Event         string `json:"event"`
+	Address       string `json:"address"`
+	Hash          string `json:"hash"`
+	Amount        string `json:"amount"`
+	PaymentID     string `json:"paymentId"`
+	BlockHeight   int64  `json:"blockHeight"`
+	Confirmations int64  `json:"confirmations"`
+}
+
+// webhookDialControl - refuses connections to loopback and private networks
+func webhookDialControl(network, address string, c syscall.RawConn) error {
+	if allowPrivateWebhooks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.New("webhook: bad address " + host)
+	}
+	for _, cidr := range privateNets {
+		if _, block, _ := net.ParseCIDR(cidr); block.Contains(ip) {
+			return errors.New("webhook: private address " + host + " not allowed")
+		}
+	}
+	return nil
+}
+
+// webhookDispatcher - queues confirmation events and delivers due webhooks
+func webhookDispatcher() {
+	for ; !shuttingDown(); pause(webhookInterval) {
+		for _, service := range serviceList {
+			if err := enqueueConfirmed(service.name, service.lastBlock()); err != nil {
+				fmt.Println("webhooks:", err)
+			}
+		}
+		if err := deliverWebhooks(); err != nil {
+			fmt.Println("webhooks:", err)
+		}
+	}
+}
+
+// enqueueReceived - queues a payment.received event for the webhooks of
+// address that fire on arrival, in the sql transaction recording the deposit
+func enqueueReceived(dbTx *sql.Tx, address string, tx *Transaction, amount int64, blockCount int64) error {
+	rows, err := dbTx.Query(`SELECT id FROM webhooks WHERE confirmations = 0
+			AND addr_id = (SELECT id FROM addresses WHERE address = $1);`, address)
+	if err != nil {
+		return err
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	confirmations := blockCount - tx.BlockIndex
+	if confirmations < 0 {
+		confirmations = 0
+	}
+	payload := webhookPayload{
+		Event:         eventPaymentRecv,
+		Address:       address,
+		Hash:          tx.TransactionHash,
+		Amount:        strconv.FormatFloat(float64(amount)/divisor, 'f', -1, 64),
+		PaymentID:     tx.PaymentID,
+		BlockHeight:   tx.BlockIndex,
+		Confirmations: confirmations,
+	}
+	for _, id := range ids {
+		if err = enqueueDelivery(dbTx, id, &payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueConfirmed - queues payment.confirmed events for deposits to the
+// addresses of backend that reached the confirmations their webhooks wait for
+func enqueueConfirmed(backend string, blockCount int64) error {
+	rows, err := walletDB.Query(`SELECT w.id, a.address, t.hash, t.amount, t.paymentID, t.block_height
+			FROM webhooks w
+			JOIN addresses a ON a.id = w.addr_id
+			JOIN transactions t ON t.addr_id = w.addr_id AND t.id > w.start_tx_id
+			WHERE a.backend = $3 AND w.confirmations > 0 AND trim(t.dest) = ''
+			AND t.block_height <= $1 - w.confirmations
+			AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d
+				WHERE d.webhook_id = w.id AND d.hash = t.hash AND d.event = $2);`,
+		blockCount, eventPaymentConfirm, backend)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type queued struct {
+		id      int
+		payload webhookPayload
+	}
+	due := []queued{}
+	for rows.Next() {
+		q := queued{payload: webhookPayload{Event: eventPaymentConfirm}}
+		err = rows.Scan(&q.id, &q.payload.Address, &q.payload.Hash, &q.payload.Amount,
+			&q.payload.PaymentID, &q.payload.BlockHeight)
+		if err != nil {
+			return err
+		}
+		q.payload.Address = strings.TrimSpace(q.payload.Address)
+		q.payload.PaymentID = strings.TrimSpace(q.payload.PaymentID)
+		q.payload.Confirmations = blockCount - q.payload.BlockHeight
+		due = append(due, q)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, q := range due {
+		if err = enqueueDelivery(walletDB, q.id, &q.payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseDeliveries - called by rollbackFrom before the deposits of backend
+// at or above height are removed. Queues a payment.reversed event for every
+// deposit whose received or confirmed event may have reached its webhook, and
+// forgets those events so they fire again if the deposit is mined again
+func reverseDeliveries(tx *sql.Tx, backend string, height int64) error {
+	rows, err := tx.Query(`SELECT DISTINCT d.webhook_id, a.address, t.hash, t.amount, t.paymentID, t.block_height
+			FROM transactions t
+			JOIN addresses a ON a.id = t.addr_id
+			JOIN webhooks w ON w.addr_id = t.addr_id
+			JOIN webhook_deliveries d ON d.webhook_id = w.id AND d.hash = t.hash AND d.event IN ($3, $4)
+			WHERE a.backend = $1 AND t.block_height >= $2 AND trim(t.dest) = ''
+			AND (d.status = 'delivered' OR d.attempts > 0);`,
+		backend, height, eventPaymentRecv, eventPaymentConfirm)
+	if err != nil {
+		return err
+	}
+	type queued struct {
+		id      int
+		payload webhookPayload
+	}
+	due := []queued{}
+	for rows.Next() {
+		q := queued{payload: webhookPayload{Event: eventPaymentReverse}}
+		err = rows.Scan(&q.id, &q.payload.Address, &q.payload.Hash, &q.payload.Amount,
+			&q.payload.PaymentID, &q.payload.BlockHeight)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		q.payload.Address = strings.TrimSpace(q.payload.Address)
+		q.payload.PaymentID = strings.TrimSpace(q.payload.PaymentID)
+		due = append(due, q)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, q := range due {
+		body, err := json.Marshal(&q.payload)
+		if err != nil {
+			return err
+		}
+		// a deposit reorged out twice is reversed twice
+		_, err = tx.Exec(`INSERT INTO webhook_deliveries (webhook_id, hash, event, payload)
+				VALUES ($1, $2, $3, $4) ON CONFLICT (webhook_id, hash, event) DO UPDATE
+				SET payload = EXCLUDED.payload, status = 'pending', attempts = 0, response_code = 0,
+				last_error = '', next_attempt = now(), created = now(), delivered = NULL;`,
+			q.id, q.payload.Hash, q.payload.Event, string(body))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM webhook_deliveries d USING transactions t, addresses a, webhooks w
+			WHERE a.id = t.addr_id AND w.addr_id = t.addr_id AND d.webhook_id = w.id AND d.hash = t.hash
+			AND d.event IN ($3, $4) AND a.backend = $1 AND t.block_height >= $2 AND trim(t.dest) = '';`,
+		backend, height, eventPaymentRecv, eventPaymentConfirm)
+	return err
+}
+
+// enqueueDelivery - stores a delivery, every event is sent once per hook and transaction
+func enqueueDelivery(q execer, webhookID int, payload *webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`INSERT INTO webhook_deliveries (webhook_id, hash, event, payload)
+			VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING;`,
+		webhookID, payload.Hash, payload.Event, string(body))
+	return err
+}
+
+// deliverWebhooks - posts the deliveries that are due
+func deliverWebhooks() error {
+	rows, err := walletDB.Query(`SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+			FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.next_attempt <= now()
+			ORDER BY d.next_attempt LIMIT $1;`, webhookBatch)
+	if err != nil {
+		return err
+	}
+	type due struct {
+		id, attempts                int
+		event, payload, url, secret string
+	}
+	deliveries := []due{}
+	for rows.Next() {
+		d := due{}
+		if err = rows.Scan(&d.id, &d.event, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range deliveries {
+		code, err := postWebhook(d.url, strings.TrimSpace(d.secret), d.event, d.id, []byte(d.payload))
+		attempts := d.attempts + 1
+		if err == nil {
+			_, err = walletDB.Exec(`UPDATE webhook_deliveries SET status = 'delivered', attempts = $2,
+					response_code = $3, last_error = '', delivered = now() WHERE id = $1;`,
+				d.id, attempts, code)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		status := "pending"
+		if attempts >= webhookMaxAttempts {
+			status = "failed"
+		}
+		_, err = walletDB.Exec(`UPDATE webhook_deliveries SET status = $2, attempts = $3, response_code = $4,
+				last_error = $5, next_attempt = now() + $6 * interval '1 second' WHERE id = $1;`,
+			d.id, status, attempts, code, err.Error(), backoff(attempts))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backoff - seconds to wait before the next attempt
+func backoff(attempts int) int {
+	delay := webhookBaseDelay
+	for i := 1; i < attempts && delay < webhookMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxDelay {
+		delay = webhookMaxDelay
+	}
+	return delay
+}
+
+// signPayload - hex encoded HMAC-SHA256 of body keyed with the webhook secret
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook - posts a signed payload, any 2xx response counts as delivered
+func postWebhook(uri, secret, event string, deliveryID int, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Shellnet-Webhook")
+	req.Header.Set("X-Shellnet-Event", event)
+	req.Header.Set("X-Shellnet-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Shellnet-Signature", "sha256="+signPayload(secret, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New("unexpected response " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// validWebhookURL - only absolute http(s) urls are accepted
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || len(raw) > 256 {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// newWebhook - registers a webhook for an address and returns its secret
+func newWebhook(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	address := req.FormValue("address")
+	uri := strings.TrimSpace(req.FormValue("url"))
+	confirmations, err := strconv.Atoi(req.FormValue("confirmations"))
+	if err != nil || confirmations < 0 || confirmations > maxConfirmations {
+		encoder.Encode(jsonResponse{Status: "Incorrect Confirmations Format"})
+		return
+	}
+	if !validWebhookURL(uri) {
+		encoder.Encode(jsonResponse{Status: "Incorrect URL Format"})
+		return
+	}
+
+	var count int
+	err = walletDB.QueryRow(`SELECT count(*) FROM webhooks
+			WHERE addr_id = (SELECT id FROM addresses WHERE address = $1);`, address).Scan(&count)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	if count >= maxWebhooks {
+		encoder.Encode(jsonResponse{Status: "Too many webhooks"})
+		return
+	}
+
+	var id int
+	secret := randomHex(32)
+	err = walletDB.QueryRow(`INSERT INTO webhooks (addr_id, url, secret, confirmations, start_tx_id)
+			SELECT a.id, $2, $3, $4, COALESCE((SELECT max(id) FROM transactions), 0)
+			FROM addresses a WHERE a.address = $1 RETURNING id;`,
+		address, uri, secret, confirmations).Scan(&id)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK",
+		Data: map[string]interface{}{"id": id, "secret": secret}})
+}
+
+// deleteWebhook - removes a webhook and its delivery log
+func deleteWebhook(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	_, err := walletDB.Exec(`DELETE FROM webhooks WHERE id = $2
+			AND addr_id = (SELECT id FROM addresses WHERE address = $1);`,
+		req.FormValue("address"), req.FormValue("id"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK"})
+}
+
+// getWebhooks - lists the webhooks of an address with their latest deliveries
+func getWebhooks(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	rows, err := walletDB.Query(`SELECT id, url, secret, confirmations, to_char(created, 'YYYY-MM-DD HH24:MI')
+			FROM webhooks WHERE addr_id = (SELECT id FROM addresses WHERE address = $1) ORDER BY id;`,
+		p.ByName("address"))
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	hooks := make([]webhook, 0)
+	for rows.Next() {
+		w := webhook{}
+		if err = rows.Scan(&w.ID, &w.URL, &w.Secret, &w.Confirmations, &w.Created); err != nil {
+			rows.Close()
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+		hooks = append(hooks, w)
+	}
+	rows.Close()
+
+	for i := range hooks {
+		if hooks[i].Deliveries, err = webhookDeliveries(hooks[i].ID); err != nil {
+			encoder.Encode(jsonResponse{Status: err.Error()})
+			return
+		}
+	}
+	encoder.Encode(jsonResponse{Status: "OK",
+		Data: map[string]interface{}{"webhooks": hooks}})
+}
+
+// webhookDeliveries - the latest deliveries of a webhook
+func webhookDeliveries(id int) ([]webhookDelivery, error) {
+	rows, err := walletDB.Query(`SELECT id, hash, event, status, attempts, response_code, last_error,
+			to_char(created, 'YYYY-MM-DD HH24:MI:SS'), to_char(next_attempt, 'YYYY-MM-DD HH24:MI:SS')
+			FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT 20;`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]webhookDelivery, 0)
+	for rows.Next() {
+		d := webhookDelivery{}
+		err = rows.Scan(&d.ID, &d.Hash, &d.Event, &d.Status, &d.Attempts, &d.ResponseCode,
+			&d.LastError, &d.Created, &d.NextAttempt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
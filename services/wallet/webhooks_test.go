@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignPayload(t *testing.T) {
+	for _, test := range []struct {
+		secret, body, want string
+	}{
+		{"key", "The quick brown fox jumps over the lazy dog",
+			"f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+		{"", "", "b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+	} {
+		if got := signPayload(test.secret, []byte(test.body)); got != test.want {
+			t.Errorf("signPayload(%q, %q) = %s, want %s", test.secret, test.body, got, test.want)
+		}
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	allowPrivateWebhooks = true
+	defer func() { allowPrivateWebhooks = false }()
+
+	body := []byte(`{"event":"payment.received"}`)
+	secret := randomHex(32)
+	var header http.Header
+	var received []byte
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		header = req.Header
+		received, _ = ioutil.ReadAll(req.Body)
+		res.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	code, err := postWebhook(server.URL, secret, eventPaymentRecv, 7, body)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("post = %d, %v", code, err)
+	}
+	if string(received) != string(body) {
+		t.Errorf("body = %s, want %s", received, body)
+	}
+	if got, want := header.Get("X-Shellnet-Signature"), "sha256="+signPayload(secret, body); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if header.Get("X-Shellnet-Event") != eventPaymentRecv || header.Get("X-Shellnet-Delivery") != "7" {
+		t.Errorf("event headers = %v", header)
+	}
+
+	status = http.StatusInternalServerError
+	if code, err = postWebhook(server.URL, secret, eventPaymentRecv, 7, body); err == nil || code != status {
+		t.Errorf("post to a failing hook = %d, %v", code, err)
+	}
+}
+
+func TestWebhookDialControl(t *testing.T) {
+	// the dialer refuses loopback unless private webhooks are allowed
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	if _, err := postWebhook(server.URL, "", eventPaymentRecv, 1, nil); err == nil {
+		t.Error("post to loopback went through")
+	}
+}
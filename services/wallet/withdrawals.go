@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxRejectReasonLen = 256
+	withdrawalsShown   = 50       // decided withdrawals listed to admins
+	withdrawalsKept    = "7 days" // decided withdrawals listed to their sender
+)
+
+// withdrawal states
+const (
+	withdrawalHeld     = "held"
+	withdrawalSending  = "sending"
+	withdrawalSent     = "sent"
+	withdrawalFailed   = "failed"
+	withdrawalRejected = "rejected"
+)
+
+// holdThreshold - sends above this many atomic units wait for an admin,
+// 0 to send everything at once
+var holdThreshold int64
+
+var (
+	errWithdrawalHeld    = errors.New("This transaction is waiting for approval")
+	errWithdrawalDecided = errors.New("Withdrawal not found or already decided")
+)
+
+// withdrawal - a send held for approval
+type withdrawal struct {
+	ID          int
+	Address     string
+	Destination string
+	PaymentID   string
+	Amount      float64
+	Status      string
+	Reason      string // why it was rejected or failed
+	Hash        string
+	DecidedBy   string
+	Created     string
+	Decided     string
+}
+
+// needsApproval - whether a send of amount atomic units is held
+func needsApproval(amount int64) bool {
+	return holdThreshold > 0 && amount > holdThreshold
+}
+
+// holdWithdrawal - stores a claimed send request for approval instead of
+// sending it. Its outcome is stored with the request once decided.
+func holdWithdrawal(request *sendRequest) (int, error) {
+	var id int
+	err := walletDB.QueryRow(`INSERT INTO withdrawals (addr_id, request_key, dest, amount, paymentID)
+			VALUES ((SELECT id FROM addresses WHERE address = $1), $2, $3, $4, $5) RETURNING id;`,
+		request.address, strings.ToLower(request.key), request.dest, float64(request.amount)/divisor,
+		request.paymentID).Scan(&id)
+	if err == nil {
+		fmt.Println("withdrawal", id, "of", float64(request.amount)/divisor, "from", request.address, "held")
+	}
+	return id, err
+}
+
+// isHeld - whether the request with key is waiting for approval
+func isHeld(key string) bool {
+	var held bool
+	walletDB.QueryRow("SELECT EXISTS (SELECT 1 FROM withdrawals WHERE request_key = $1 AND status = $2);",
+		strings.ToLower(key), withdrawalHeld).Scan(&held)
+	return held
+}
+
+// queryWithdrawals - the withdrawals matching where, newest first
+func queryWithdrawals(where string, args ...interface{}) ([]withdrawal, error) {
+	rows, err := walletDB.Query(`SELECT w.id, a.address, w.dest, w.paymentID, w.amount, w.status, w.reason,
+				COALESCE(w.hash, ''), w.decided_by, to_char(w.created, 'YYYY-MM-DD HH24:MI:SS'),
+				COALESCE(to_char(w.decided, 'YYYY-MM-DD HH24:MI:SS'), '')
+			FROM withdrawals w JOIN addresses a ON a.id = w.addr_id
+			WHERE `+where+` ORDER BY w.id DESC;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	withdrawals := make([]withdrawal, 0)
+	for rows.Next() {
+		w := withdrawal{}
+		err = rows.Scan(&w.ID, &w.Address, &w.Destination, &w.PaymentID, &w.Amount, &w.Status, &w.Reason,
+			&w.Hash, &w.DecidedBy, &w.Created, &w.Decided)
+		if err != nil {
+			return nil, err
+		}
+		w.Address = strings.TrimSpace(w.Address)
+		w.Hash = strings.TrimSpace(w.Hash)
+		withdrawals = append(withdrawals, w)
+	}
+	return withdrawals, rows.Err()
+}
+
+// getWithdrawals - the withdrawals waiting for approval and the latest
+// decided ones, for admins
+func getWithdrawals(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	held, err := queryWithdrawals("w.status = $1", withdrawalHeld)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	decided, err := queryWithdrawals(`w.id IN (SELECT id FROM withdrawals WHERE status != $1
+			ORDER BY id DESC LIMIT $2)`, withdrawalHeld, withdrawalsShown)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{
+		"held":      held,
+		"decided":   decided,
+		"threshold": float64(holdThreshold) / divisor,
+	}})
+}
+
+// getAddressWithdrawals - the held withdrawals of an address and the ones
+// decided lately
+func getAddressWithdrawals(res http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	withdrawals, err := queryWithdrawals(`a.address = $1
+			AND (w.status = $2 OR w.decided > now() - $3::interval)`,
+		p.ByName("address"), withdrawalHeld, withdrawalsKept)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"withdrawals": withdrawals}})
+}
+
+// decideWithdrawal - takes a held withdrawal out of the queue for admin,
+// who may not decide their own (admin_address)
+func decideWithdrawal(req *http.Request, status, reason string) (int, *sendRequest, error) {
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		return 0, nil, errWithdrawalDecided
+	}
+	admin := strings.TrimSpace(req.FormValue("admin"))
+	if admin == "" {
+		return 0, nil, errors.New("Missing admin")
+	}
+	request := &sendRequest{}
+	var amount float64
+	err = walletDB.QueryRow(`UPDATE withdrawals w SET status = $2, reason = $3, decided_by = $4, decided = now()
+			FROM addresses a WHERE w.id = $1 AND w.status = 'held' AND a.id = w.addr_id AND a.address != $5
+			RETURNING a.address, w.request_key, w.dest, w.amount, w.paymentID;`,
+		id, status, reason, admin, req.FormValue("admin_address")).Scan(
+		&request.address, &request.key, &request.dest, &amount, &request.paymentID)
+	if err == sql.ErrNoRows {
+		var own bool
+		walletDB.QueryRow(`SELECT EXISTS (SELECT 1 FROM withdrawals w JOIN addresses a ON a.id = w.addr_id
+				WHERE w.id = $1 AND w.status = 'held' AND a.address = $2);`,
+			id, req.FormValue("admin_address")).Scan(&own)
+		if own {
+			return 0, nil, errors.New("Withdrawals can't be decided by their sender")
+		}
+		return 0, nil, errWithdrawalDecided
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	request.address = strings.TrimSpace(request.address)
+	request.amount = int64(math.Round(amount * divisor))
+	fmt.Println("withdrawal", id, status, "by", admin)
+	return id, request, nil
+}
+
+// closeWithdrawal - stores the outcome of an approved withdrawal
+func closeWithdrawal(id int, status, reason, hash string) {
+	_, err := walletDB.Exec("UPDATE withdrawals SET status = $2, reason = $3, hash = NULLIF($4, '') WHERE id = $1;",
+		id, status, reason, hash)
+	if err != nil {
+		fmt.Println("withdrawal", id, err)
+	}
+}
+
+// approveWithdrawal - sends a held withdrawal. It stays held while its
+// backend can't send.
+func approveWithdrawal(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	var address string
+	walletDB.QueryRow(`SELECT a.address FROM withdrawals w JOIN addresses a ON a.id = w.addr_id
+			WHERE w.id = $1;`, req.FormValue("id")).Scan(&address)
+	if _, err := senderFor(strings.TrimSpace(address)); err != nil && err != errUnknownAddress {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	id, request, err := decideWithdrawal(req, withdrawalSending, "")
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	backend, err := senderFor(request.address)
+	hash := ""
+	if err == nil {
+		// not bound to the request, the outcome has to be recorded even if
+		// the caller goes away
+		ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+		hash, err = backend.SendTransaction(ctx, &TransactionRequest{
+			Addresses: []string{request.address},
+			Transfers: []Destination{{Address: request.dest, Amount: request.amount}},
+			Fee:       transactionFee,
+			Anonymity: 3, // mixin
+			PaymentID: request.paymentID,
+		})
+		if err != nil && ctx.Err() != nil {
+			err = errSendUnknown
+		}
+		cancel()
+	}
+	finishSend(request, hash, err)
+	switch {
+	case err == nil:
+		closeWithdrawal(id, withdrawalSent, "", hash)
+	case err == errSendUnknown:
+		// walletd may still relay it, so it stays marked as sending
+		closeWithdrawal(id, withdrawalSending, err.Error(), "")
+	default:
+		settleSpend(request.key, 0)
+		closeWithdrawal(id, withdrawalFailed, err.Error(), "")
+	}
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	encoder.Encode(jsonResponse{Status: "OK", Data: map[string]interface{}{"transactionHash": hash}})
+}
+
+// rejectWithdrawal - refuses a held withdrawal with a reason shown to its
+// sender
+func rejectWithdrawal(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	encoder := json.NewEncoder(res)
+	reason := strings.TrimSpace(req.FormValue("reason"))
+	if reason == "" || len(reason) > maxRejectReasonLen {
+		encoder.Encode(jsonResponse{Status: "Reason must be between 1 and 256 characters"})
+		return
+	}
+	_, request, err := decideWithdrawal(req, withdrawalRejected, reason)
+	if err != nil {
+		encoder.Encode(jsonResponse{Status: err.Error()})
+		return
+	}
+	finishSend(request, "", errors.New("Withdrawal rejected: "+reason))
+	settleSpend(request.key, 0)
+	encoder.Encode(jsonResponse{Status: "OK"})
+}
@@ -4,7 +4,10 @@ CREATE DATABASE tx_history;
 
 CREATE TABLE addresses (
 ID serial NOT NULL PRIMARY KEY,
-address char(99) not null unique);
+address char(99) not null unique,
+backend varchar(32) NOT NULL DEFAULT 'default',
+watch_only boolean NOT NULL DEFAULT false,
+seed_backup timestamp);
 
 CREATE TABLE transactions (
 ID serial NOT NULL PRIMARY KEY,
@@ -12,4 +15,177 @@ addr_id serial references addresses(id),
 DEST char(99),
 AMOUNT numeric(15,2) NOT NULL,
 hash char(64) NOT NULL,
-paymentID char(64) not null);
+paymentID char(64) not null,
+block_height bigint NOT NULL DEFAULT 0,
+block_time timestamp,
+unlock_time bigint NOT NULL DEFAULT 0,
+fusion boolean NOT NULL DEFAULT false,
+fee numeric(15,2) NOT NULL DEFAULT 0);
+
+CREATE TABLE integrated_addresses (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+address char(187) NOT NULL unique,
+paymentID char(64) NOT NULL,
+label varchar(64) NOT NULL DEFAULT '',
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE block_hashes (
+backend varchar(32) NOT NULL DEFAULT 'default',
+height bigint NOT NULL,
+hash char(64) NOT NULL,
+PRIMARY KEY (backend, height));
+
+CREATE TABLE pending_transactions (
+hash char(64) NOT NULL,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+DEST char(99) NOT NULL DEFAULT '',
+AMOUNT numeric(15,2) NOT NULL,
+paymentID char(64) NOT NULL,
+fusion boolean NOT NULL DEFAULT false,
+seen timestamp NOT NULL DEFAULT now(),
+PRIMARY KEY (hash, addr_id, dest));
+
+CREATE TABLE send_requests (
+request_key char(32) NOT NULL PRIMARY KEY,
+address char(99) NOT NULL,
+dest varchar(187) NOT NULL,
+AMOUNT numeric(15,2) NOT NULL,
+paymentID varchar(64) NOT NULL DEFAULT '',
+hash char(64),
+error text,
+batch text, /* json of the transactions sent for a batch */
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE webhooks (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+url varchar(256) NOT NULL,
+secret char(64) NOT NULL,
+confirmations integer NOT NULL DEFAULT 0,
+start_tx_id integer NOT NULL DEFAULT 0,
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE webhook_deliveries (
+ID serial NOT NULL PRIMARY KEY,
+webhook_id integer NOT NULL references webhooks(id) ON DELETE CASCADE,
+hash char(64) NOT NULL,
+event varchar(32) NOT NULL,
+payload text NOT NULL,
+status varchar(16) NOT NULL DEFAULT 'pending',
+attempts integer NOT NULL DEFAULT 0,
+response_code integer NOT NULL DEFAULT 0,
+last_error text NOT NULL DEFAULT '',
+next_attempt timestamp NOT NULL DEFAULT now(),
+created timestamp NOT NULL DEFAULT now(),
+delivered timestamp,
+UNIQUE (webhook_id, hash, event));
+
+CREATE TABLE invoices (
+ID serial NOT NULL PRIMARY KEY,
+public_id char(32) NOT NULL unique,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+integrated_address char(187) NOT NULL,
+paymentID char(64) NOT NULL unique,
+AMOUNT numeric(15,2) NOT NULL,
+received numeric(15,2) NOT NULL DEFAULT 0,
+description varchar(256) NOT NULL DEFAULT '',
+status varchar(16) NOT NULL DEFAULT 'open',
+expires timestamp NOT NULL,
+created timestamp NOT NULL DEFAULT now(),
+paid timestamp);
+
+CREATE TABLE delayed_transactions (
+hash char(64) NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+request_key char(32) NOT NULL unique,
+DEST varchar(187) NOT NULL,
+AMOUNT numeric(15,2) NOT NULL,
+paymentID varchar(64) NOT NULL DEFAULT '',
+fee numeric(15,2) NOT NULL,
+inputs numeric(15,2) NOT NULL,
+change numeric(15,2) NOT NULL,
+status varchar(16) NOT NULL DEFAULT 'created',
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE contacts (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+name varchar(64) NOT NULL,
+address varchar(187) NOT NULL,
+paymentID varchar(64) NOT NULL DEFAULT '',
+notes varchar(256) NOT NULL DEFAULT '');
+
+CREATE TABLE scan_checkpoint (
+backend varchar(32) NOT NULL PRIMARY KEY,
+height bigint NOT NULL,
+block_hash char(64) NOT NULL DEFAULT '',
+updated timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE backfills (
+addr_id integer NOT NULL PRIMARY KEY references addresses(id) ON DELETE CASCADE,
+backend varchar(32) NOT NULL DEFAULT 'default',
+height bigint NOT NULL,
+until_height bigint NOT NULL,
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE watch_addresses (
+owner_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+label varchar(64) NOT NULL DEFAULT '',
+created timestamp NOT NULL DEFAULT now(),
+PRIMARY KEY (owner_id, addr_id));
+
+CREATE TABLE spend_policies (
+addr_id integer NOT NULL PRIMARY KEY references addresses(id) ON DELETE CASCADE,
+daily_limit numeric(15,2) NOT NULL DEFAULT 0,
+weekly_limit numeric(15,2) NOT NULL DEFAULT 0,
+tx_limit numeric(15,2) NOT NULL DEFAULT 0,
+allowlist_only boolean NOT NULL DEFAULT false,
+updated timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE spend_policy_changes (
+addr_id integer NOT NULL PRIMARY KEY references addresses(id) ON DELETE CASCADE,
+daily_limit numeric(15,2) NOT NULL DEFAULT 0,
+weekly_limit numeric(15,2) NOT NULL DEFAULT 0,
+tx_limit numeric(15,2) NOT NULL DEFAULT 0,
+allowlist_only boolean NOT NULL DEFAULT false,
+effective timestamp NOT NULL,
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE allowed_destinations (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+address varchar(187) NOT NULL,
+label varchar(64) NOT NULL DEFAULT '',
+effective timestamp NOT NULL DEFAULT now(),
+created timestamp NOT NULL DEFAULT now(),
+UNIQUE (addr_id, address));
+
+CREATE TABLE spends (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+reference varchar(64) NOT NULL,
+AMOUNT bigint NOT NULL, /* atomic units */
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE spend_events (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+event varchar(32) NOT NULL,
+detail text NOT NULL DEFAULT '',
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE withdrawals (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+request_key char(32) NOT NULL unique,
+DEST varchar(187) NOT NULL,
+AMOUNT numeric(15,2) NOT NULL,
+paymentID varchar(64) NOT NULL DEFAULT '',
+status varchar(16) NOT NULL DEFAULT 'held',
+reason text NOT NULL DEFAULT '',
+hash char(64),
+decided_by varchar(64) NOT NULL DEFAULT '',
+created timestamp NOT NULL DEFAULT now(),
+decided timestamp);
@@ -0,0 +1,191 @@
+-- upgrade a transaction database created by an earlier version, safe to run
+-- again: cat transaction_db_upgrade.sql | psql -U <username> -h <host>
+\c tx_history;
+
+ALTER TABLE transactions
+ADD COLUMN IF NOT EXISTS block_height bigint NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS block_time timestamp,
+ADD COLUMN IF NOT EXISTS unlock_time bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS integrated_addresses (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+address char(187) NOT NULL unique,
+paymentID char(64) NOT NULL,
+label varchar(64) NOT NULL DEFAULT '',
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS block_hashes (
+backend varchar(32) NOT NULL DEFAULT 'default',
+height bigint NOT NULL,
+hash char(64) NOT NULL,
+PRIMARY KEY (backend, height));
+
+CREATE TABLE IF NOT EXISTS pending_transactions (
+hash char(64) NOT NULL,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+DEST char(99) NOT NULL DEFAULT '',
+AMOUNT numeric(15,2) NOT NULL,
+paymentID char(64) NOT NULL,
+fusion boolean NOT NULL DEFAULT false,
+seen timestamp NOT NULL DEFAULT now(),
+PRIMARY KEY (hash, addr_id, dest));
+
+CREATE TABLE IF NOT EXISTS send_requests (
+request_key char(32) NOT NULL PRIMARY KEY,
+address char(99) NOT NULL,
+dest varchar(187) NOT NULL,
+AMOUNT numeric(15,2) NOT NULL,
+paymentID varchar(64) NOT NULL DEFAULT '',
+hash char(64),
+error text,
+batch text, /* json of the transactions sent for a batch */
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS webhooks (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+url varchar(256) NOT NULL,
+secret char(64) NOT NULL,
+confirmations integer NOT NULL DEFAULT 0,
+start_tx_id integer NOT NULL DEFAULT 0,
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+ID serial NOT NULL PRIMARY KEY,
+webhook_id integer NOT NULL references webhooks(id) ON DELETE CASCADE,
+hash char(64) NOT NULL,
+event varchar(32) NOT NULL,
+payload text NOT NULL,
+status varchar(16) NOT NULL DEFAULT 'pending',
+attempts integer NOT NULL DEFAULT 0,
+response_code integer NOT NULL DEFAULT 0,
+last_error text NOT NULL DEFAULT '',
+next_attempt timestamp NOT NULL DEFAULT now(),
+created timestamp NOT NULL DEFAULT now(),
+delivered timestamp,
+UNIQUE (webhook_id, hash, event));
+
+CREATE TABLE IF NOT EXISTS invoices (
+ID serial NOT NULL PRIMARY KEY,
+public_id char(32) NOT NULL unique,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+integrated_address char(187) NOT NULL,
+paymentID char(64) NOT NULL unique,
+AMOUNT numeric(15,2) NOT NULL,
+received numeric(15,2) NOT NULL DEFAULT 0,
+description varchar(256) NOT NULL DEFAULT '',
+status varchar(16) NOT NULL DEFAULT 'open',
+expires timestamp NOT NULL,
+created timestamp NOT NULL DEFAULT now(),
+paid timestamp);
+
+ALTER TABLE transactions
+ADD COLUMN IF NOT EXISTS fusion boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS delayed_transactions (
+hash char(64) NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+request_key char(32) NOT NULL unique,
+DEST varchar(187) NOT NULL,
+AMOUNT numeric(15,2) NOT NULL,
+paymentID varchar(64) NOT NULL DEFAULT '',
+fee numeric(15,2) NOT NULL,
+inputs numeric(15,2) NOT NULL,
+change numeric(15,2) NOT NULL,
+status varchar(16) NOT NULL DEFAULT 'created',
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS contacts (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+name varchar(64) NOT NULL,
+address varchar(187) NOT NULL,
+paymentID varchar(64) NOT NULL DEFAULT '',
+notes varchar(256) NOT NULL DEFAULT '');
+
+ALTER TABLE transactions
+ADD COLUMN IF NOT EXISTS fee numeric(15,2) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS scan_checkpoint (
+backend varchar(32) NOT NULL PRIMARY KEY,
+height bigint NOT NULL,
+block_hash char(64) NOT NULL DEFAULT '',
+updated timestamp NOT NULL DEFAULT now());
+
+ALTER TABLE addresses
+ADD COLUMN IF NOT EXISTS backend varchar(32) NOT NULL DEFAULT 'default';
+
+CREATE TABLE IF NOT EXISTS backfills (
+addr_id integer NOT NULL PRIMARY KEY references addresses(id) ON DELETE CASCADE,
+backend varchar(32) NOT NULL DEFAULT 'default',
+height bigint NOT NULL,
+until_height bigint NOT NULL,
+created timestamp NOT NULL DEFAULT now());
+
+ALTER TABLE addresses
+ADD COLUMN IF NOT EXISTS watch_only boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS watch_addresses (
+owner_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+label varchar(64) NOT NULL DEFAULT '',
+created timestamp NOT NULL DEFAULT now(),
+PRIMARY KEY (owner_id, addr_id));
+
+ALTER TABLE addresses
+ADD COLUMN IF NOT EXISTS seed_backup timestamp;
+
+CREATE TABLE IF NOT EXISTS spend_policies (
+addr_id integer NOT NULL PRIMARY KEY references addresses(id) ON DELETE CASCADE,
+daily_limit numeric(15,2) NOT NULL DEFAULT 0,
+weekly_limit numeric(15,2) NOT NULL DEFAULT 0,
+tx_limit numeric(15,2) NOT NULL DEFAULT 0,
+allowlist_only boolean NOT NULL DEFAULT false,
+updated timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS spend_policy_changes (
+addr_id integer NOT NULL PRIMARY KEY references addresses(id) ON DELETE CASCADE,
+daily_limit numeric(15,2) NOT NULL DEFAULT 0,
+weekly_limit numeric(15,2) NOT NULL DEFAULT 0,
+tx_limit numeric(15,2) NOT NULL DEFAULT 0,
+allowlist_only boolean NOT NULL DEFAULT false,
+effective timestamp NOT NULL,
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS allowed_destinations (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+address varchar(187) NOT NULL,
+label varchar(64) NOT NULL DEFAULT '',
+effective timestamp NOT NULL DEFAULT now(),
+created timestamp NOT NULL DEFAULT now(),
+UNIQUE (addr_id, address));
+
+CREATE TABLE IF NOT EXISTS spends (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+reference varchar(64) NOT NULL,
+AMOUNT bigint NOT NULL, /* atomic units */
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS spend_events (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+event varchar(32) NOT NULL,
+detail text NOT NULL DEFAULT '',
+created timestamp NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS withdrawals (
+ID serial NOT NULL PRIMARY KEY,
+addr_id integer NOT NULL references addresses(id) ON DELETE CASCADE,
+request_key char(32) NOT NULL unique,
+DEST varchar(187) NOT NULL,
+AMOUNT numeric(15,2) NOT NULL,
+paymentID varchar(64) NOT NULL DEFAULT '',
+status varchar(16) NOT NULL DEFAULT 'held',
+reason text NOT NULL DEFAULT '',
+hash char(64),
+decided_by varchar(64) NOT NULL DEFAULT '',
+created timestamp NOT NULL DEFAULT now(),
+decided timestamp);